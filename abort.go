@@ -0,0 +1,109 @@
+package lpstest
+
+import (
+	"fmt"
+	"lpstest/lib"
+	"sync"
+)
+
+// 滑动窗口中结果的种类
+const (
+	outcomeSuccess uint8 = iota
+	outcomeError
+	outcomeTimeout
+	outcomeOther // 其他未成功的结果
+)
+
+// 触发中止策略时用于取消上下文的原因
+type abortError struct {
+	msg string
+}
+
+func (e *abortError) Error() string {
+	return e.msg
+}
+
+// 按中止策略观察调用结果
+type abortMonitor struct {
+	policy   lib.AbortPolicy
+	lock     sync.Mutex
+	failures uint32  // 连续未成功的结果数
+	window   []uint8 // 最近的结果，循环使用
+	next     int     // 下一个结果在窗口中的位置
+	filled   bool    // 窗口是否已填满
+	errors   int     // 窗口中的错误数
+	timeouts int     // 窗口中的超时数
+}
+
+func newAbortMonitor(policy lib.AbortPolicy) *abortMonitor {
+	return &abortMonitor{
+		policy: policy,
+		window: make([]uint8, policy.WindowSize()),
+	}
+}
+
+// 获取结果的种类
+func outcomeOf(code lib.RetCode) uint8 {
+	switch {
+	case code == lib.RET_CODE_SUCCESS:
+		return outcomeSuccess
+	case code == lib.RET_CODE_WARNING_CALL_TIMEOUT:
+		return outcomeTimeout
+	case code >= lib.RET_CODE_ERROR_CALL:
+		return outcomeError
+	default:
+		return outcomeOther
+	}
+}
+
+// 记录一个调用结果，满足中止条件时返回中止的原因，否则返回 nil
+func (m *abortMonitor) observe(result *lib.CallResult) error {
+	outcome := outcomeOf(result.Code)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if outcome == outcomeSuccess {
+		m.failures = 0
+	} else {
+		m.failures++
+	}
+
+	// 移出窗口中最旧的结果
+	if m.filled {
+		switch m.window[m.next] {
+		case outcomeError:
+			m.errors--
+		case outcomeTimeout:
+			m.timeouts--
+		}
+	}
+	m.window[m.next] = outcome
+	switch outcome {
+	case outcomeError:
+		m.errors++
+	case outcomeTimeout:
+		m.timeouts++
+	}
+	m.next++
+	if m.next == len(m.window) {
+		m.next = 0
+		m.filled = true
+	}
+
+	policy := m.policy
+	if policy.MaxConsecutiveFailures > 0 && m.failures >= policy.MaxConsecutiveFailures {
+		return &abortError{fmt.Sprintf("Aborted! (consecutive failures: %d)", m.failures)}
+	}
+	if !m.filled {
+		return nil
+	}
+	size := float64(len(m.window))
+	if ratio := float64(m.errors) / size; policy.MaxErrorRatio > 0 && ratio > policy.MaxErrorRatio {
+		return &abortError{fmt.Sprintf("Aborted! (error ratio: %.2f > %.2f in the last %d results)",
+			ratio, policy.MaxErrorRatio, len(m.window))}
+	}
+	if ratio := float64(m.timeouts) / size; policy.MaxTimeoutRatio > 0 && ratio > policy.MaxTimeoutRatio {
+		return &abortError{fmt.Sprintf("Aborted! (timeout ratio: %.2f > %.2f in the last %d results)",
+			ratio, policy.MaxTimeoutRatio, len(m.window))}
+	}
+	return nil
+}
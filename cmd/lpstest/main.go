@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// 退出码
+const (
+	EXIT_OK     = 0 // 运行完成且通过了所有阈值
+	EXIT_FAILED = 1 // 运行完成但未通过阈值
+	EXIT_ERROR  = 2 // 参数错误或无法运行
+)
+
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "\tlpstest <command> [flags]\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "\trun\tRun a load test against a target.\n")
+	fmt.Fprintf(os.Stderr, "\tsearch\tSearch for the maximum sustainable lps of a target.\n")
+	fmt.Fprintf(os.Stderr, "Use \"lpstest <command> -h\" for the flags of a command.\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		Usage()
+		os.Exit(EXIT_ERROR)
+	}
+	var code int
+	switch os.Args[1] {
+	case "run":
+		code = runCmd(os.Args[2:], os.Stdout, os.Stderr)
+	case "search":
+		code = searchCmd(os.Args[2:], os.Stdout, os.Stderr)
+	case "-h", "-help", "--help", "help":
+		Usage()
+	default:
+		fmt.Fprintf(os.Stderr, "lpstest: unknown command %q\n", os.Args[1])
+		Usage()
+		code = EXIT_ERROR
+	}
+	os.Exit(code)
+}
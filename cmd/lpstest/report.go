@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"lpstest/lib"
+	"lpstest/plan"
+	"lpstest/stats"
+	helper "lpstest/testhelper"
+	"lpstest/threshold"
+	"os"
+)
+
+// 一次运行的结果
+type runResult struct {
+	Summary   stats.Summary     `json:"summary"`   // 结果的统计摘要，时长的单位为纳秒
+	Generator lib.GenStats      `json:"generator"` // 载荷发生器的统计信息
+	Stop      lib.StopReason    `json:"stop"`      // 载荷发生器停止的原因
+	Verdict   threshold.Verdict `json:"verdict"`   // 阈值的评估结果
+	// 持久连接的统计信息，只在使用 tcp 连接池时非空
+	Conns *helper.ConnStats `json:"conns,omitempty"`
+}
+
+// 输出文本格式的报告
+func writeTextReport(w io.Writer, result runResult) {
+	fmt.Fprintf(w, "Final report:\n%s", result.Summary)
+	fmt.Fprintf(w, "generator: calls=%d, ticket waits=%d (%v), missed loads=%d, max schedule lag=%v\n",
+		result.Generator.CallCount, result.Generator.TicketWaits,
+		result.Generator.TicketWaitNS, result.Generator.MissedLoads, result.Generator.MaxScheduleLag)
+	rs := result.Generator.Results
+	fmt.Fprintf(w, "results: sent=%d, timeouts=%d, panics=%d, abandoned=%d, late responses=%d (recorded=%d), ignored=%d (stopped=%d, full=%d), complete=%v\n",
+		rs.Sent, rs.Timeouts, rs.Panics, rs.Abandoned, rs.LateResponses, rs.LateRecorded, rs.Ignored(),
+		rs.IgnoredStopped, rs.IgnoredFull, rs.Complete(result.Generator.CallCount))
+	if conns := result.Conns; conns != nil {
+		fmt.Fprintf(w, "connections: dials=%d, reconnects=%d, broken=%d, dial errors=%d, connect time: mean=%v, max=%v\n",
+			conns.Dials, conns.Reconnects, conns.Broken, conns.DialErrors, conns.MeanConnectNS(), conns.MaxConnectNS)
+	}
+	fmt.Fprintf(w, "stopped: %s\n", result.Stop)
+	fmt.Fprint(w, result.Verdict)
+}
+
+// 按测试计划的配置输出报告，路径为 - 时输出到 stdout
+func writeReports(reports []plan.ReportSpec, result runResult, stdout io.Writer) error {
+	for _, report := range reports {
+		if err := writeReport(report, result, stdout); err != nil {
+			return fmt.Errorf("writing report %s: %w", report.Path, err)
+		}
+	}
+	return nil
+}
+
+// 输出一份报告
+func writeReport(report plan.ReportSpec, result runResult, stdout io.Writer) error {
+	w := stdout
+	if report.Path != "-" {
+		file, err := os.Create(report.Path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	if report.Format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+	writeTextReport(w, result)
+	return nil
+}
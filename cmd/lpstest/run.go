@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"lpstest"
+	"lpstest/httpcaller"
+	"lpstest/lib"
+	"lpstest/log"
+	"lpstest/log/base"
+	"lpstest/plan"
+	"lpstest/stats"
+	helper "lpstest/testhelper"
+	"lpstest/threshold"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 可以重复指定的请求头参数
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("invalid header %q (expected: \"Key: Value\")", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// 可以重复指定的阈值参数
+type thresholdFlags []string
+
+func (t *thresholdFlags) String() string {
+	return strings.Join(*t, ", ")
+}
+
+func (t *thresholdFlags) Set(value string) error {
+	if _, err := threshold.Parse(value); err != nil {
+		return err
+	}
+	*t = append(*t, value)
+	return nil
+}
+
+// run 子命令的参数
+type runOptions struct {
+	plan         string
+	target       string
+	caller       string
+	lps          uint
+	timeout      time.Duration
+	duration     time.Duration
+	drain        time.Duration
+	method       string
+	headers      headerFlags
+	body         string
+	interval     time.Duration
+	logLevel     string
+	maxP99       time.Duration
+	maxErrorRate float64
+	thresholds   thresholdFlags
+	recordLate   bool
+	conns        int
+	framing      string
+}
+
+// 注册 run 和 search 子命令共用的调用器和阈值参数
+func registerCallerFlags(fs *flag.FlagSet, opts *runOptions) {
+	fs.StringVar(&opts.target, "target", "", "The target address: host:port for tcp, URL (template) for http.")
+	fs.StringVar(&opts.caller, "caller", "http", "The caller type: tcp or http.")
+	fs.IntVar(&opts.conns, "conns", 0, "The number of persistent connections of the tcp caller, 0 to dial per request.")
+	fs.StringVar(&opts.framing, "framing", "delim", "The message framing of the tcp caller: delim, length or varint.")
+	fs.DurationVar(&opts.timeout, "timeout", time.Second, "The timeout of each call.")
+	fs.DurationVar(&opts.drain, "drain", 5*time.Second, "The deadline for draining in-flight calls when stopping.")
+	fs.StringVar(&opts.method, "method", "GET", "The request method of the http caller.")
+	fs.Var(&opts.headers, "header", "A request header \"Key: Value\" of the http caller, can be repeated.")
+	fs.StringVar(&opts.body, "body", "", "The request body (template) of the http caller.")
+	fs.StringVar(&opts.logLevel, "log-level", "warn", "The log level: debug, info, warn or error.")
+	fs.DurationVar(&opts.maxP99, "max-p99", 0, "Fail if the p99 response time exceeds it, 0 to disable the check.")
+	fs.Float64Var(&opts.maxErrorRate, "max-error-rate", -1, "Fail if the ratio of unsuccessful results exceeds it (0~1), negative to disable the check.")
+	fs.Var(&opts.thresholds, "threshold", "A threshold like \"p95 Elapse < 200ms\" or \"achieved LPS >= 95% of target\", can be repeated.")
+}
+
+// 解析 run 子命令的参数
+func parseRunOptions(args []string, stderr io.Writer) (*runOptions, error) {
+	var opts runOptions
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&opts.plan, "plan", "", "The test plan file (YAML or JSON), replaces the caller and load flags.")
+	fs.UintVar(&opts.lps, "lps", 100, "The loads per second.")
+	fs.DurationVar(&opts.duration, "duration", 10*time.Second, "The duration of the run.")
+	fs.DurationVar(&opts.interval, "interval", time.Second, "The interval of the live summary, 0 to disable it.")
+	fs.BoolVar(&opts.recordLate, "record-late", false, "Record responses arriving after their timeout as late results.")
+	registerCallerFlags(fs, &opts)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage of run:\n")
+		fmt.Fprintf(stderr, "\tlpstest run -target <target> [flags]\n")
+		fmt.Fprintf(stderr, "\tlpstest run -plan <file> [flags]\n")
+		fmt.Fprintf(stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if opts.target == "" && opts.plan == "" {
+		return nil, errors.New("the flag named target or plan is required")
+	}
+	if opts.lps == 0 || opts.lps > 1<<32-1 {
+		return nil, fmt.Errorf("invalid lps %d", opts.lps)
+	}
+	return &opts, nil
+}
+
+// 根据参数新建调用器
+func newCaller(opts *runOptions) (lib.Caller, error) {
+	switch opts.caller {
+	case "tcp":
+		codec, err := helper.NewCodec(opts.framing, 0)
+		if err != nil {
+			return nil, err
+		}
+		if opts.conns > 0 {
+			return helper.NewTCPPoolWithCodec(opts.target, opts.conns, codec)
+		}
+		return helper.NewTCPCommWithCodec(opts.target, codec), nil
+	case "http":
+		headers := make(map[string]string)
+		for _, header := range opts.headers {
+			kv := strings.SplitN(header, ":", 2)
+			headers[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+		return httpcaller.NewHTTPCaller(httpcaller.Config{
+			Method:  opts.method,
+			URL:     opts.target,
+			Headers: headers,
+			Body:    opts.body,
+		})
+	default:
+		return nil, fmt.Errorf("unknown caller type %q", opts.caller)
+	}
+}
+
+// 根据参数或测试计划生成载荷发生器的参数（不含 ResultCh 和 Sink），
+// 测试计划中的阈值会与命令行指定的阈值合并
+func newParamSet(opts *runOptions) (lpstest.ParamSet, threshold.Set, []plan.ReportSpec, error) {
+	thresholds, err := parseThresholds(opts)
+	if err != nil {
+		return lpstest.ParamSet{}, nil, nil, err
+	}
+	if opts.plan == "" {
+		caller, err := newCaller(opts)
+		if err != nil {
+			return lpstest.ParamSet{}, nil, nil, err
+		}
+		return lpstest.ParamSet{
+			Caller:     caller,
+			TimeoutNS:  opts.timeout,
+			LPS:        uint32(opts.lps),
+			DurationNS: opts.duration,
+			DrainNS:    opts.drain,
+			RecordLate: opts.recordLate,
+		}, thresholds, nil, nil
+	}
+	p, err := plan.Load(opts.plan)
+	if err != nil {
+		return lpstest.ParamSet{}, nil, nil, fmt.Errorf("invalid plan:\n%w", err)
+	}
+	pset, err := p.ParamSet(nil)
+	if err != nil {
+		return lpstest.ParamSet{}, nil, nil, fmt.Errorf("invalid plan:\n%w", err)
+	}
+	planThresholds, err := p.ThresholdSet()
+	if err != nil {
+		return lpstest.ParamSet{}, nil, nil, fmt.Errorf("invalid plan:\n%w", err)
+	}
+	opts.caller, opts.target = p.Caller.Type, p.Caller.Target
+	pset.RecordLate = pset.RecordLate || opts.recordLate
+	return pset, append(thresholds, planThresholds...), p.Reports, nil
+}
+
+// 解析命令行指定的阈值
+func parseThresholds(opts *runOptions) (threshold.Set, error) {
+	var exprs []string
+	if opts.maxP99 > 0 {
+		exprs = append(exprs, fmt.Sprintf("p99 ResponseTime <= %v", opts.maxP99))
+	}
+	if opts.maxErrorRate >= 0 {
+		exprs = append(exprs, "error rate <= "+strconv.FormatFloat(opts.maxErrorRate, 'f', -1, 64))
+	}
+	exprs = append(exprs, opts.thresholds...)
+	return threshold.ParseSet(exprs...)
+}
+
+// 计算持续时长内的平均目标载荷量
+func targetLPS(pset lpstest.ParamSet) float64 {
+	if pset.Profile == nil {
+		return float64(pset.LPS)
+	}
+	const samples = 1000
+	var sum float64
+	for i := 0; i < samples; i++ {
+		elapsed := pset.DurationNS * time.Duration(i) / samples
+		sum += float64(pset.Profile.LPS(elapsed))
+	}
+	return sum / samples
+}
+
+// 解析日志级别
+func parseLogLevel(level string) (base.LogLevel, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return base.LEVEL_DEBUG, nil
+	case "info":
+		return base.LEVEL_INFO, nil
+	case "warn":
+		return base.LEVEL_WARN, nil
+	case "error":
+		return base.LEVEL_ERROR, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", level)
+}
+
+// 保证日志记录器只被设置一次
+var loggerOnce sync.Once
+
+// 设置载荷发生器的日志记录器，只有第一次调用有效
+func setupLogger(level base.LogLevel, writer io.Writer) {
+	loggerOnce.Do(func() {
+		lpstest.SetLogger(log.Logger(base.TYPE_LOGRUS, level, base.FORMAT_TEXT, writer, nil))
+	})
+}
+
+// 执行 run 子命令，返回退出码
+func runCmd(args []string, stdout, stderr io.Writer) int {
+	opts, err := parseRunOptions(args, stderr)
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(stderr, "lpstest run: %s\n", err)
+		}
+		return EXIT_ERROR
+	}
+	level, err := parseLogLevel(opts.logLevel)
+	if err != nil {
+		fmt.Fprintf(stderr, "lpstest run: %s\n", err)
+		return EXIT_ERROR
+	}
+	setupLogger(level, stderr)
+	pset, thresholds, reports, err := newParamSet(opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "lpstest run: %s\n", err)
+		return EXIT_ERROR
+	}
+	maxLPS := pset.LPS
+	if pset.Profile != nil {
+		maxLPS = pset.Profile.MaxLPS()
+	}
+	// 结果直接汇总到收集器中，不会因消费不及时而丢失
+	collector := stats.NewCollector()
+	done := make(chan struct{})
+	pset.Sink = lib.NewFuncSink(collector.Add, func() { close(done) })
+	gen, err := lpstest.NewGenerator(pset)
+	if err != nil {
+		fmt.Fprintf(stderr, "lpstest run: %s\n", err)
+		return EXIT_ERROR
+	}
+	// 预先建立持久连接，使建立连接的耗时不计入请求的时间
+	pool, _ := pset.Caller.(*helper.TCPPool)
+	if pool != nil {
+		defer pool.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), pset.TimeoutNS)
+		err := pool.Connect(ctx)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(stderr, "lpstest run: connecting to %s: %s\n", opts.target, err)
+			return EXIT_ERROR
+		}
+	}
+
+	// 收到中断信号时提前停止
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	defer signal.Stop(sigCh)
+
+	var tick <-chan time.Time
+	if opts.interval > 0 {
+		ticker := time.NewTicker(opts.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	fmt.Fprintf(stdout, "Running %s load test against %s (max lps=%d, timeout=%v, duration=%v)...\n",
+		opts.caller, opts.target, maxLPS, pset.TimeoutNS, pset.DurationNS)
+	gen.Start()
+	for running := true; running; {
+		select {
+		case <-tick:
+			genStats := gen.Stats()
+			printLive(stdout, genStats, collector.Snapshot(genStats.ActiveNS))
+		case <-sigCh:
+			fmt.Fprintln(stdout, "Interrupted, stopping...")
+			go gen.Stop()
+		case <-done:
+			running = false
+		}
+	}
+
+	// 吞吐量按实际发送载荷的时长计算，不含暂停和停止时排空的时间
+	genStats := gen.Stats()
+	elapsed := genStats.ActiveNS
+	result := runResult{
+		Summary:   collector.Snapshot(elapsed),
+		Generator: genStats,
+		Stop:      gen.StopReason(),
+		Verdict:   thresholds.Evaluate(threshold.NewInput(collector, elapsed, targetLPS(pset))),
+	}
+	if pool != nil {
+		conns := pool.ConnStats()
+		result.Conns = &conns
+	}
+	fmt.Fprintln(stdout)
+	writeTextReport(stdout, result)
+	if err := writeReports(reports, result, stdout); err != nil {
+		fmt.Fprintf(stderr, "lpstest run: %s\n", err)
+		return EXIT_ERROR
+	}
+	// 因中止策略提前停止的运行视为未通过
+	if !result.Verdict.Passed || result.Stop.Kind == lib.STOP_REASON_ABORTED {
+		return EXIT_FAILED
+	}
+	return EXIT_OK
+}
+
+// 打印运行中的统计摘要
+func printLive(w io.Writer, genStats lib.GenStats, summary stats.Summary) {
+	fmt.Fprintf(w, "[%6.1fs] calls=%d results=%d in-flight=%d lag=%v throughput=%.1f/s p99=%v errors=%.2f%%\n",
+		genStats.ActiveNS.Seconds(), genStats.CallCount, summary.Count, genStats.InFlight, genStats.ScheduleLag,
+		summary.Throughput, summary.Response.P99, errorRate(summary)*100)
+}
+
+// 计算未成功的结果所占的比例
+func errorRate(summary stats.Summary) float64 {
+	if summary.Count == 0 {
+		return 0
+	}
+	return 1 - summary.Ratio(lib.RET_CODE_SUCCESS)
+}
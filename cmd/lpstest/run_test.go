@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	helper "lpstest/testhelper"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCmd(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"passed", []string{"-max-error-rate", "0.01"}, EXIT_OK},
+		{"failed", []string{"-max-p99", "1ns"}, EXIT_FAILED},
+		{"threshold", []string{"-threshold", "p95 Elapse < 1s", "-threshold", "count > 0"}, EXIT_OK},
+		{"threshold failed", []string{"-threshold", "achieved LPS >= 200% of target"}, EXIT_FAILED},
+	}
+	for _, c := range cases {
+		args := append([]string{
+			"-target", server.URL, "-lps", "50", "-duration", "500ms", "-interval", "200ms",
+		}, c.args...)
+		var stdout, stderr bytes.Buffer
+		code := runCmd(args, &stdout, &stderr)
+		t.Logf("Output of %s:\n%s", c.name, stdout.String())
+		if code != c.code {
+			t.Errorf("Inconsistent exit code for %s: expected: %d, actual: %d (stderr: %s)", c.name, c.code, code, stderr.String())
+		}
+	}
+}
+
+func TestRunCmdDrain(t *testing.T) {
+	// 响应较慢，停止时需要排空正在进行的调用
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	args := []string{
+		"-target", server.URL, "-lps", "100", "-duration", "1s", "-drain", "1s", "-interval", "0",
+		"-threshold", "achieved LPS >= 95% of target",
+	}
+	var stdout, stderr bytes.Buffer
+	code := runCmd(args, &stdout, &stderr)
+	t.Logf("Output:\n%s", stdout.String())
+	// 排空的时间不计入吞吐量
+	if code != EXIT_OK {
+		t.Fatalf("Inconsistent exit code: expected: %d, actual: %d (stderr: %s)", EXIT_OK, code, stderr.String())
+	}
+}
+
+func TestRunCmdTCPPool(t *testing.T) {
+	server := helper.NewTCPServerWithCodec(helper.NewLengthCodec(0))
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("TCP Server startup failing: %s", err)
+	}
+	defer server.Close()
+
+	args := []string{
+		"-caller", "tcp", "-target", server.Addr().String(), "-conns", "2", "-framing", "length",
+		"-lps", "200", "-duration", "500ms", "-interval", "0", "-max-error-rate", "0",
+	}
+	var stdout, stderr bytes.Buffer
+	code := runCmd(args, &stdout, &stderr)
+	t.Logf("Output:\n%s", stdout.String())
+	if code != EXIT_OK {
+		t.Fatalf("Inconsistent exit code: expected: %d, actual: %d (stderr: %s)", EXIT_OK, code, stderr.String())
+	}
+	// 两个连接在运行前建立，运行中不再建立连接
+	if !strings.Contains(stdout.String(), "connections: dials=2, reconnects=0, broken=0") {
+		t.Fatal("Missing connection stats in the report!")
+	}
+}
+
+func TestRunCmdInvalidFlags(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := runCmd([]string{"-lps", "10"}, &stdout, &stderr); code != EXIT_ERROR {
+		t.Fatalf("Inconsistent exit code: expected: %d, actual: %d", EXIT_ERROR, code)
+	}
+	if code := runCmd([]string{"-target", "x", "-caller", "udp"}, &stdout, &stderr); code != EXIT_ERROR {
+		t.Fatalf("Inconsistent exit code: expected: %d, actual: %d", EXIT_ERROR, code)
+	}
+	if code := runCmd([]string{"-target", "x", "-caller", "tcp", "-framing", "xml"}, &stdout, &stderr); code != EXIT_ERROR {
+		t.Fatalf("Inconsistent exit code: expected: %d, actual: %d", EXIT_ERROR, code)
+	}
+	if code := runCmd([]string{"-target", "x", "-threshold", "p95 < 1s"}, &stdout, &stderr); code != EXIT_ERROR {
+		t.Fatalf("Inconsistent exit code: expected: %d, actual: %d", EXIT_ERROR, code)
+	}
+}
+
+func TestRunCmdPlan(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	reportFile := filepath.Join(dir, "report.json")
+	planFile := filepath.Join(dir, "plan.yaml")
+	data := fmt.Sprintf(`caller:
+  type: http
+  target: %s
+load:
+  lps: 50
+  timeout: 1s
+  duration: 500ms
+  drain: 1s
+thresholds:
+  max_error_rate: 0.01
+  rules:
+    - achieved LPS >= 80%% of target
+reports:
+  - format: json
+    path: %s
+`, server.URL, reportFile)
+	if err := os.WriteFile(planFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	if code := runCmd([]string{"-plan", planFile, "-interval", "0"}, &stdout, &stderr); code != EXIT_OK {
+		t.Fatalf("Inconsistent exit code: expected: %d, actual: %d (stdout: %s, stderr: %s)", EXIT_OK, code, stdout.String(), stderr.String())
+	}
+	content, err := os.ReadFile(reportFile)
+	if err != nil {
+		t.Fatalf("Reading the report failing: %s", err)
+	}
+	var result runResult
+	if err := json.Unmarshal(content, &result); err != nil {
+		t.Fatalf("Invalid report: %s", err)
+	}
+	if !result.Verdict.Passed || len(result.Verdict.Results) != 2 || result.Summary.Count == 0 {
+		t.Fatalf("Inconsistent report: %s", content)
+	}
+
+	os.WriteFile(planFile, []byte("load:\n  lps: 0\n"), 0644)
+	stderr.Reset()
+	if code := runCmd([]string{"-plan", planFile}, &stdout, &stderr); code != EXIT_ERROR {
+		t.Fatalf("Inconsistent exit code: expected: %d, actual: %d", EXIT_ERROR, code)
+	}
+	t.Logf("Expected error: %s", stderr.String())
+}
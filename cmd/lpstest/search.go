@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"lpstest"
+	"lpstest/search"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// search 子命令的参数
+type searchOptions struct {
+	runOptions
+	strategy  string
+	minLPS    uint
+	maxLPS    uint
+	step      uint
+	precision uint
+	level     time.Duration
+	cooldown  time.Duration
+}
+
+// 解析 search 子命令的参数
+func parseSearchOptions(args []string, stderr io.Writer) (*searchOptions, error) {
+	var opts searchOptions
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&opts.strategy, "strategy", "binary", "The search strategy: binary or step.")
+	fs.UintVar(&opts.minLPS, "min-lps", 10, "The lower bound of the search.")
+	fs.UintVar(&opts.maxLPS, "max-lps", 10000, "The upper bound of the search.")
+	fs.UintVar(&opts.step, "step", 100, "The lps increment of each level for the step strategy.")
+	fs.UintVar(&opts.precision, "precision", 10, "The binary search stops when the bounds are this close.")
+	fs.DurationVar(&opts.level, "level-duration", 10*time.Second, "The duration of each level.")
+	fs.DurationVar(&opts.cooldown, "cooldown", time.Second, "The pause between levels.")
+	registerCallerFlags(fs, &opts.runOptions)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage of search:\n")
+		fmt.Fprintf(stderr, "\tlpstest search -target <target> [flags]\n")
+		fmt.Fprintf(stderr, "Without thresholds, a level is sustainable when %q and %q.\n",
+			"achieved LPS >= 95% of target", "error rate < 1%")
+		fmt.Fprintf(stderr, "Flags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if opts.target == "" {
+		return nil, errors.New("the flag named target is required")
+	}
+	if opts.maxLPS > 1<<32-1 || opts.step > 1<<32-1 || opts.precision > 1<<32-1 {
+		return nil, errors.New("lps flags out of range")
+	}
+	return &opts, nil
+}
+
+// 执行 search 子命令，返回退出码
+func searchCmd(args []string, stdout, stderr io.Writer) int {
+	opts, err := parseSearchOptions(args, stderr)
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(stderr, "lpstest search: %s\n", err)
+		}
+		return EXIT_ERROR
+	}
+	level, err := parseLogLevel(opts.logLevel)
+	if err != nil {
+		fmt.Fprintf(stderr, "lpstest search: %s\n", err)
+		return EXIT_ERROR
+	}
+	setupLogger(level, stderr)
+	thresholds, err := parseThresholds(&opts.runOptions)
+	if err != nil {
+		fmt.Fprintf(stderr, "lpstest search: %s\n", err)
+		return EXIT_ERROR
+	}
+	caller, err := newCaller(&opts.runOptions)
+	if err != nil {
+		fmt.Fprintf(stderr, "lpstest search: %s\n", err)
+		return EXIT_ERROR
+	}
+	cfg := search.Config{
+		ParamSet: lpstest.ParamSet{
+			Caller:    caller,
+			TimeoutNS: opts.timeout,
+			DrainNS:   opts.drain,
+		},
+		Thresholds: thresholds,
+		MinLPS:     uint32(opts.minLPS),
+		MaxLPS:     uint32(opts.maxLPS),
+		Step:       uint32(opts.step),
+		Precision:  uint32(opts.precision),
+		LevelNS:    opts.level,
+		CooldownNS: opts.cooldown,
+		OnPoint: func(p search.Point) {
+			fmt.Fprintf(stdout, "lps=%d throughput=%.1f/s p99=%v errors=%.2f%% sustainable=%v\n",
+				p.LPS, p.Summary.Throughput, p.Summary.Response.P99,
+				errorRate(p.Summary)*100, p.Sustainable)
+		},
+	}
+	switch opts.strategy {
+	case "binary":
+		cfg.Strategy = search.STRATEGY_BINARY
+	case "step":
+		cfg.Strategy = search.STRATEGY_STEP_UP
+	default:
+		fmt.Fprintf(stderr, "lpstest search: unknown strategy %q\n", opts.strategy)
+		return EXIT_ERROR
+	}
+
+	// 收到中断信号时停止搜索，并报告已测得的结果
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fmt.Fprintf(stdout, "Searching the max sustainable lps of %s between %d and %d (%s)...\n",
+		opts.target, opts.minLPS, opts.maxLPS, opts.strategy)
+	result, err := search.Run(ctx, cfg)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fmt.Fprintf(stderr, "lpstest search: %s\n", err)
+		return EXIT_ERROR
+	}
+	fmt.Fprintf(stdout, "\n%s", result)
+	if result.MaxLPS == 0 {
+		fmt.Fprintln(stdout, "No sustainable lps found.")
+		return EXIT_FAILED
+	}
+	return EXIT_OK
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSearchCmd(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	args := []string{
+		"-target", server.URL, "-strategy", "step", "-min-lps", "20", "-max-lps", "40", "-step", "20",
+		"-level-duration", "300ms", "-cooldown", "0", "-threshold", "p99 ResponseTime < 1s",
+	}
+	var stdout, stderr bytes.Buffer
+	code := searchCmd(args, &stdout, &stderr)
+	t.Logf("Output:\n%s", stdout.String())
+	if code != EXIT_OK {
+		t.Fatalf("Inconsistent exit code: expected: %d, actual: %d (stderr: %s)", EXIT_OK, code, stderr.String())
+	}
+	if !bytes.Contains(stdout.Bytes(), []byte("max sustainable lps: 40")) {
+		t.Fatal("The max sustainable lps was not reported!")
+	}
+
+	if code := searchCmd([]string{"-target", server.URL, "-strategy", "random"}, &stdout, &stderr); code != EXIT_ERROR {
+		t.Fatalf("Inconsistent exit code: expected: %d, actual: %d", EXIT_ERROR, code)
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"lpstest/lib"
 	"lpstest/log"
+	"lpstest/log/base"
 	"math"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -15,18 +17,59 @@ import (
 // 初始化日志模块
 var logger = log.DLogger()
 
+// 替换载荷发生器使用的日志记录器，需在新建载荷发生器之前调用
+func SetLogger(l base.MyLogger) {
+	if l != nil {
+		logger = l
+	}
+}
+
 type myGenerator struct {
-	caller      lib.Caller
-	timeoutNS   time.Duration
-	lps         uint32
-	durationNs  time.Duration
-	concurrency uint32
-	tickets     lib.GoTickets
-	ctx         context.Context
-	cancelFunc  context.CancelFunc
-	callCount   int64
-	status      uint32
-	resultCh    chan *lib.CallResult
+	caller         lib.Caller
+	ctxCaller      lib.ContextCaller // 调用器支持上下文时非空
+	timeoutNS      time.Duration
+	profile        lib.LoadProfile
+	profileLock    sync.RWMutex  // 载荷曲线的读写锁
+	replanCh       chan struct{} // 通知发送循环重新计划发送时刻
+	arrival        lib.ArrivalProcess
+	durationNs     time.Duration
+	remainingNS    time.Duration // 尚未用掉的持续时长
+	resumedAt      time.Time     // 最近一次启动或恢复的时刻
+	activeNS       time.Duration // 停止发送载荷时已运行的时长，停止前为 -1
+	budgetTimer    *time.Timer   // 持续时长用尽时取消上下文
+	resumeCh       chan struct{} // 暂停时非空，恢复时被关闭
+	runLock        sync.Mutex    // 暂停和恢复相关字段的专用锁
+	concurrency    uint32
+	maxInFlight    uint32 // 非 0 时固定并发量，不随载荷量调整
+	tickets        lib.GoTickets
+	ticketWaits    int64     // 因票池耗尽而等待的次数
+	ticketWaitNS   int64     // 等待票的总时长
+	missedLoads    int64     // 停止时已到期但未能发出的载荷数
+	scheduleLag    int64     // 发送循环最近一次落后于时间表的时长
+	maxScheduleLag int64     // 发送循环落后于时间表的最大时长
+	lastWarnAt     time.Time // 上一次提示票池耗尽的时刻，只在发送循环中使用
+	ctx            context.Context
+	cancelFunc     context.CancelCauseFunc
+	callCtx        context.Context    // 调用使用的上下文，排空结束后才被取消
+	callCancel     context.CancelFunc // 调用上下文的取消函数
+	sinkCtx        context.Context    // 等待接收方时使用的上下文，排空结束后被取消
+	sinkCancel     context.CancelFunc // 等待接收方的上下文的取消函数
+	drainNS        time.Duration      // 停止时等待正在进行的调用的期限
+	inFlight       sync.WaitGroup     // 正在进行的调用
+	inFlightNum    int64              // 正在进行的调用数
+	pending        sync.Map           // 尚未得出结果的调用
+	callCount      int64
+	status         uint32
+	sink           lib.ResultSink
+	backpressure   lib.Backpressure
+	resultSeq      uint64 // 已发送的结果数，用于采样
+	results        resultCounters
+	recordLate     bool         // 是否发送迟到的结果
+	resultLock     sync.RWMutex // 保护结果的发送与接收方的关闭
+	resultClosed   bool         // 接收方是否已关闭
+	abortPolicy    *lib.AbortPolicy
+	abort          *abortMonitor                  // 启用了中止策略时非空，每次启动时重建
+	stopReason     atomic.Pointer[lib.StopReason] // 停止的原因，尚未停止时为 nil
 }
 
 func NewGenerator(pset ParamSet) (lib.Generator, error) {
@@ -34,13 +77,34 @@ func NewGenerator(pset ParamSet) (lib.Generator, error) {
 	if err := pset.Check(); err != nil {
 		return nil, err
 	}
+	profile := pset.Profile
+	if profile == nil {
+		var err error
+		profile, err = lib.NewConstantProfile(pset.LPS)
+		if err != nil {
+			return nil, err
+		}
+	}
+	arrival := pset.Arrival
+	if arrival == nil {
+		arrival = lib.NewConstantArrival()
+	}
+	ctxCaller, _ := pset.Caller.(lib.ContextCaller)
 	gen := &myGenerator{
-		caller:     pset.Caller,
-		timeoutNS:  pset.TimeoutNS,
-		lps:        pset.LPS,
-		durationNs: pset.DurationNS,
-		status:     lib.STATUS_ORIGINAL,
-		resultCh:   pset.ResultCh,
+		caller:       pset.Caller,
+		ctxCaller:    ctxCaller,
+		timeoutNS:    pset.TimeoutNS,
+		profile:      profile,
+		replanCh:     make(chan struct{}, 1),
+		arrival:      arrival,
+		durationNs:   pset.DurationNS,
+		drainNS:      pset.DrainNS,
+		maxInFlight:  pset.MaxInFlight,
+		abortPolicy:  pset.AbortPolicy,
+		status:       lib.STATUS_ORIGINAL,
+		sink:         resultSink(pset.ResultCh, pset.Sink),
+		backpressure: pset.Backpressure,
+		recordLate:   pset.RecordLate,
 	}
 	logger.Infoln("New a load generator...1")
 	if err := gen.init(); err != nil {
@@ -55,191 +119,631 @@ func (gen *myGenerator) init() error {
 	var buf bytes.Buffer
 	buf.WriteString("Initializing the load generator...")
 
-	// 载荷的并发量 ≈ 载荷的响应超时时间 / 载荷的发送间隔时间
-	var total64 = int64(gen.timeoutNS)/int64(1e9/gen.lps) + 1
-	if total64 > math.MaxInt32 {
-		total64 = math.MaxInt32
+	// 载荷曲线会变化时按其最大载荷量估算
+	gen.concurrency = gen.maxInFlight
+	if gen.concurrency == 0 {
+		gen.concurrency = gen.calcConcurrency(gen.profile.MaxLPS())
 	}
-	gen.concurrency = uint32(total64)
 	tickets, err := lib.NewGoTickets(gen.concurrency)
 	if err != nil {
 		return err
 	}
 	gen.tickets = tickets
-	buf.WriteString(fmt.Sprintf("Done. (concurrency=%d)", gen.concurrency))
+	buf.WriteString(fmt.Sprintf("Done. (concurrency=%d, context caller=%v)", gen.concurrency, gen.ctxCaller != nil))
 	logger.Infoln(buf.String())
 	return nil
 }
 
+// 计算载荷的并发量
+func (gen *myGenerator) calcConcurrency(lps uint32) uint32 {
+	// 载荷的并发量 ≈ 载荷的响应超时时间 / 载荷的发送间隔时间
+	var total64 = int64(gen.timeoutNS)/int64(1e9/lps) + 1
+	if total64 > math.MaxInt32 {
+		total64 = math.MaxInt32
+	}
+	return uint32(total64)
+}
+
+// 获取当前的载荷曲线
+func (gen *myGenerator) currentProfile() lib.LoadProfile {
+	gen.profileLock.RLock()
+	defer gen.profileLock.RUnlock()
+	return gen.profile
+}
+
+// 获取已运行的时长，不含暂停的时间
+func (gen *myGenerator) elapsed() time.Duration {
+	gen.runLock.Lock()
+	defer gen.runLock.Unlock()
+	return gen.elapsedLocked()
+}
+
+// 获取实际发送载荷的时长，不含暂停的时间和停止时排空的时间
+func (gen *myGenerator) active() time.Duration {
+	gen.runLock.Lock()
+	defer gen.runLock.Unlock()
+	if gen.activeNS >= 0 {
+		return gen.activeNS
+	}
+	return gen.elapsedLocked()
+}
+
+// 获取已运行的时长，须持有 runLock
+func (gen *myGenerator) elapsedLocked() time.Duration {
+	elapsed := gen.durationNs - gen.remainingNS
+	if gen.resumeCh == nil {
+		elapsed += time.Since(gen.resumedAt)
+	}
+	return elapsed
+}
+
+// 获取暂停时用于等待恢复的通道，未暂停时返回 nil
+func (gen *myGenerator) pausedCh() <-chan struct{} {
+	gen.runLock.Lock()
+	defer gen.runLock.Unlock()
+	if gen.resumeCh == nil {
+		return nil
+	}
+	return gen.resumeCh
+}
+
+// 通知发送循环重新计划
+func (gen *myGenerator) replan() {
+	select {
+	case gen.replanCh <- struct{}{}:
+	default:
+	}
+}
+
 // 会向载荷承受方发起一次调用
 func (gen *myGenerator) callOne(rawReq *lib.RawReq) *lib.RawResp {
-	atomic.AddInt64(&gen.callCount, 1) // 原子操作
 	if rawReq == nil {
 		return &lib.RawResp{ID: -1, Err: errors.New("Invalid raw request.")}
 	}
 	start := time.Now().UnixNano()
-	resp, err := gen.caller.Call(rawReq.Req, gen.timeoutNS)
+	var resp []byte
+	var err error
+	var connectNS time.Duration
+	if gen.ctxCaller != nil {
+		// 超时或放弃调用时中止
+		ctx, cancel := context.WithTimeout(gen.callCtx, gen.timeoutNS)
+		ctx, connected := lib.WithConnectRecorder(ctx)
+		resp, err = gen.ctxCaller.CallContext(ctx, rawReq.Req)
+		cancel()
+		connectNS = connected()
+	} else {
+		resp, err = gen.caller.Call(rawReq.Req, gen.timeoutNS)
+	}
 	end := time.Now().UnixNano()
-	elapsedTime := time.Duration(end - start)
+	// 建立连接的耗时单独记录，不计入服务时间
+	elapsedTime := time.Duration(end-start) - connectNS
 	var rawResp lib.RawResp
 	if err != nil {
-		errMsg := fmt.Sprintf("Sync Call Error: %s.", err)
 		rawResp = lib.RawResp{
-			ID:     rawReq.ID,
-			Err:    errors.New(errMsg),
-			Elapse: elapsedTime,
+			ID:        rawReq.ID,
+			Err:       fmt.Errorf("Sync Call Error: %w.", err),
+			Elapse:    elapsedTime,
+			ConnectNS: connectNS,
 		}
 	} else {
 		rawResp = lib.RawResp{
-			ID:     rawReq.ID,
-			Resp:   resp,
-			Elapse: elapsedTime,
+			ID:        rawReq.ID,
+			Resp:      resp,
+			Elapse:    elapsedTime,
+			ConnectNS: connectNS,
 		}
 	}
 	return &rawResp
 }
 
-// 会异步地调用承受方接口
-func (gen *myGenerator) asyncCall() {
-	gen.tickets.Take()
+// 调用的状态，只有把状态从 callPending 改掉的一方才能发送调用结果
+const (
+	callPending   uint32 = iota // 未完成
+	callResponded               // 已响应
+	callTimeout                 // 已超时
+	callPanicked                // 发生了恐慌
+	callAbandoned               // 停止时被放弃
+)
+
+// 代表一次正在进行的调用
+type pendingCall struct {
+	status   uint32
+	intended time.Time                  // 按发送计划应发出调用的时刻
+	begin    time.Time                  // 记录这次调用的时刻
+	rawReq   atomic.Pointer[lib.RawReq] // 构建好的请求，构建之前为 nil
+}
+
+// 拿走一张票，票池耗尽时记录等待情况，载荷发生器停止时返回 false
+func (gen *myGenerator) takeTicket() bool {
+	if gen.tickets.TryTake() {
+		return true
+	}
+	atomic.AddInt64(&gen.ticketWaits, 1)
+	if time.Since(gen.lastWarnAt) >= time.Second {
+		gen.lastWarnAt = time.Now()
+		logger.Warnf("Goroutine ticket pool is exhausted, loads are behind schedule. (concurrency=%d, in-flight=%d)",
+			gen.tickets.Total(), atomic.LoadInt64(&gen.inFlightNum))
+	}
+	start := time.Now()
+	ok := gen.tickets.TakeContext(gen.ctx)
+	atomic.AddInt64(&gen.ticketWaitNS, int64(time.Since(start)))
+	return ok
+}
+
+// 会异步地调用承受方接口，未能发出时返回 false
+// 参数 intended 代表按发送计划应发出调用的时刻
+func (gen *myGenerator) asyncCall(intended time.Time) bool {
+	if !gen.takeTicket() {
+		return false
+	}
+	call := gen.addCall(intended)
 	go func() {
-		defer func() {
-			if p := recover(); p != nil {
-				err, ok := any(p).(error)
-				var errMsg string
-				if ok {
-					errMsg = fmt.Sprintf("Async Call Panic! (error: %s)", err)
-				} else {
-					errMsg = fmt.Sprintf("Async Call Panic! (clue: %#v)", p)
-				}
-				logger.Errorln(errMsg)
-				result := &lib.CallResult{
-					ID:   -1,
-					Code: lib.RET_CODE_FATAL_CALL,
-					Msg:  errMsg,
-				}
-				gen.sendResult(result)
+		defer gen.tickets.Return()
+		gen.syncCall(call)
+	}()
+	return true
+}
+
+// 记录并登记一次将要发出的调用，须在 syncCall 之前调用
+func (gen *myGenerator) addCall(intended time.Time) *pendingCall {
+	// 在发出时计数并登记，保证每次调用都对应一个结果，
+	// 即使停止时它还未开始也会作为被放弃的调用得到结果
+	call := &pendingCall{intended: intended, begin: time.Now()}
+	atomic.AddInt64(&gen.callCount, 1)
+	atomic.AddInt64(&gen.inFlightNum, 1)
+	gen.inFlight.Add(1)
+	gen.pending.Store(call, struct{}{})
+	return call
+}
+
+// 会同步地调用承受方接口并发送结果，调用返回之后才返回，
+// 但超时的结果会在超时之时发送
+func (gen *myGenerator) syncCall(call *pendingCall) {
+	defer gen.inFlight.Done()
+	defer atomic.AddInt64(&gen.inFlightNum, -1)
+	defer gen.pending.Delete(call)
+	intended := call.intended
+	var owned bool // 是否已由响应方取得了发送结果的权利
+	var late bool  // 响应是否在超时或被放弃之后才返回
+	defer func() {
+		if p := recover(); p != nil {
+			err, ok := any(p).(error)
+			var errMsg string
+			if ok {
+				errMsg = fmt.Sprintf("Async Call Panic! (error: %s)", err)
+			} else {
+				errMsg = fmt.Sprintf("Async Call Panic! (clue: %#v)", p)
 			}
-		}()
-		rawReq := gen.caller.BuildRed()
-		var callStatus uint32
-		timer := time.AfterFunc(gen.timeoutNS, func() {
-			if !atomic.CompareAndSwapUint32(&callStatus, 0, 2) {
+			logger.Errorln(errMsg)
+			atomic.AddInt64(&gen.results.panics, 1)
+			if !owned && !atomic.CompareAndSwapUint32(&call.status, callPending, callPanicked) {
+				if !late {
+					atomic.AddInt64(&gen.results.late, 1)
+				}
 				return
 			}
 			result := &lib.CallResult{
-				ID:     rawReq.ID,
-				Req:    rawReq,
-				Code:   lib.RET_CODE_WARNING_CALL_TIMEOUT,
-				Msg:    fmt.Sprintf("Timeout! (expected: < %v)", gen.timeoutNS),
-				Elapse: gen.timeoutNS,
+				ID:           -1,
+				Code:         lib.RET_CODE_FATAL_CALL,
+				Msg:          errMsg,
+				ResponseTime: time.Since(intended),
 			}
 			gen.sendResult(result)
-		})
-		rawResp := gen.callOne(&rawReq)
-		if !atomic.CompareAndSwapUint32(&callStatus, 0, 1) {
-			return
 		}
+	}()
+	// 开始之前已被放弃
+	if atomic.LoadUint32(&call.status) != callPending {
+		return
+	}
+	rawReq := gen.caller.BuildRed()
+	rawReq.Intended = intended
+	call.rawReq.Store(&rawReq)
+	// 超时时发送超时的结果，已由其他方发送结果时返回 false
+	onTimeout := func() bool {
+		if !atomic.CompareAndSwapUint32(&call.status, callPending, callTimeout) {
+			return false
+		}
+		atomic.AddInt64(&gen.results.timeouts, 1)
+		result := &lib.CallResult{
+			ID:     rawReq.ID,
+			Req:    rawReq,
+			Code:   lib.RET_CODE_WARNING_CALL_TIMEOUT,
+			Msg:    fmt.Sprintf("Timeout! (expected: < %v)", gen.timeoutNS),
+			Elapse: gen.timeoutNS,
+			// 超时是从实际发出调用时算起的，响应时间则从计划时刻算起
+			ResponseTime: time.Since(intended),
+		}
+		gen.sendResult(result)
+		return true
+	}
+	timer := time.AfterFunc(gen.timeoutNS, func() { onTimeout() })
+	rawResp := gen.callOne(&rawReq)
+	// 调用因超时而中止时，计时器的回调可能尚未执行，由这里发送超时的结果；
+	// 中止并不是迟到的响应，因此不计入迟到的响应
+	if errors.Is(rawResp.Err, context.DeadlineExceeded) {
 		timer.Stop()
-		var result *lib.CallResult
-		if rawResp.Err != nil {
-			result = &lib.CallResult{
-				ID:     rawResp.ID,
-				Req:    rawReq,
-				Code:   lib.RET_CODE_ERROR_CALL,
-				Msg:    rawResp.Err.Error(),
-				Elapse: rawResp.Elapse,
-			}
-		} else {
-			result = gen.caller.CheckResp(rawReq, *rawResp)
-			result.Elapse = rawResp.Elapse
+		onTimeout()
+		return
+	}
+	// 调用因停止而中止时结果已作为被放弃的调用发送过，中止同样不计入迟到的响应
+	if errors.Is(rawResp.Err, context.Canceled) && atomic.LoadUint32(&call.status) == callAbandoned {
+		timer.Stop()
+		return
+	}
+	responseTime := time.Since(intended)
+	if !atomic.CompareAndSwapUint32(&call.status, callPending, callResponded) {
+		// 超时或被放弃之后才返回，结果已经发送过了
+		late = true
+		atomic.AddInt64(&gen.results.late, 1)
+		if gen.recordLate {
+			result := gen.checkResp(rawReq, rawResp, responseTime)
+			result.Late = true
+			gen.sendLate(result)
+		}
+		return
+	}
+	owned = true
+	timer.Stop()
+	gen.sendResult(gen.checkResp(rawReq, rawResp, responseTime))
+}
+
+// 根据响应生成调用结果
+func (gen *myGenerator) checkResp(rawReq lib.RawReq, rawResp *lib.RawResp, responseTime time.Duration) *lib.CallResult {
+	var result *lib.CallResult
+	if rawResp.Err != nil {
+		result = &lib.CallResult{
+			ID:     rawResp.ID,
+			Req:    rawReq,
+			Code:   lib.RET_CODE_ERROR_CALL,
+			Msg:    rawResp.Err.Error(),
+			Elapse: rawResp.Elapse,
+		}
+	} else {
+		result = gen.caller.CheckResp(rawReq, *rawResp)
+		result.Elapse = rawResp.Elapse
+	}
+	result.ConnectNS = rawResp.ConnectNS
+	result.ResponseTime = responseTime
+	return result
+}
+
+// 调用结果的计数器
+type resultCounters struct {
+	sent           int64
+	timeouts       int64
+	panics         int64
+	abandoned      int64
+	late           int64
+	lateRecorded   int64
+	ignoredStopped int64
+	ignoredFull    int64
+}
+
+// 清零所有计数
+func (c *resultCounters) reset() {
+	atomic.StoreInt64(&c.sent, 0)
+	atomic.StoreInt64(&c.timeouts, 0)
+	atomic.StoreInt64(&c.panics, 0)
+	atomic.StoreInt64(&c.abandoned, 0)
+	atomic.StoreInt64(&c.late, 0)
+	atomic.StoreInt64(&c.lateRecorded, 0)
+	atomic.StoreInt64(&c.ignoredStopped, 0)
+	atomic.StoreInt64(&c.ignoredFull, 0)
+}
+
+// 获取计数的快照
+func (c *resultCounters) snapshot() lib.ResultStats {
+	return lib.ResultStats{
+		Sent:           atomic.LoadInt64(&c.sent),
+		Timeouts:       atomic.LoadInt64(&c.timeouts),
+		Panics:         atomic.LoadInt64(&c.panics),
+		Abandoned:      atomic.LoadInt64(&c.abandoned),
+		LateResponses:  atomic.LoadInt64(&c.late),
+		LateRecorded:   atomic.LoadInt64(&c.lateRecorded),
+		IgnoredStopped: atomic.LoadInt64(&c.ignoredStopped),
+		IgnoredFull:    atomic.LoadInt64(&c.ignoredFull),
+	}
+}
+
+// 放弃所有尚未完成的调用，并为它们发送结果
+func (gen *myGenerator) abandonPending() {
+	gen.pending.Range(func(key, _ any) bool {
+		call := key.(*pendingCall)
+		if !atomic.CompareAndSwapUint32(&call.status, callPending, callAbandoned) {
+			return true
+		}
+		atomic.AddInt64(&gen.results.abandoned, 1)
+		// 尚未构建请求时只能给出计划的发送时刻
+		rawReq := lib.RawReq{ID: -1, Intended: call.intended}
+		if req := call.rawReq.Load(); req != nil {
+			rawReq = *req
+		}
+		result := &lib.CallResult{
+			ID:           rawReq.ID,
+			Req:          rawReq,
+			Code:         lib.RET_CODE_WARNING_CALL_ABANDONED,
+			Msg:          fmt.Sprintf("Abandoned! (drain deadline exceeded: %v)", gen.drainNS),
+			Elapse:       time.Since(call.begin),
+			ResponseTime: time.Since(call.intended),
 		}
 		gen.sendResult(result)
-	}()
+		return true
+	})
 }
 
 // 发送调用结果
 func (gen *myGenerator) sendResult(result *lib.CallResult) bool {
-	if atomic.LoadUint32(&gen.status) != lib.STATUS_STARTED {
+	if gen.abort != nil {
+		if err := gen.abort.observe(result); err != nil {
+			gen.cancelFunc(err)
+		}
+	}
+	gen.resultLock.RLock()
+	defer gen.resultLock.RUnlock()
+	if gen.resultClosed {
+		atomic.AddInt64(&gen.results.ignoredStopped, 1)
 		gen.printIgnoredResult(result, "stopped load generator")
 		return false
 	}
-	select {
-	case gen.resultCh <- result:
-		return true
-	default:
-		gen.printIgnoredResult(result, "full result channel")
+	if !gen.putResult(result) {
+		atomic.AddInt64(&gen.results.ignoredFull, 1)
+		gen.printIgnoredResult(result, "full result sink")
 		return false
 	}
+	atomic.AddInt64(&gen.results.sent, 1)
+	return true
+}
+
+// 发送迟到的结果，它已经以超时或放弃的结果发送过一次，
+// 因此不计入中止策略，也不影响结果是否完整
+func (gen *myGenerator) sendLate(result *lib.CallResult) bool {
+	gen.resultLock.RLock()
+	defer gen.resultLock.RUnlock()
+	if gen.resultClosed {
+		gen.printIgnoredResult(result, "late response of stopped load generator")
+		return false
+	}
+	if !gen.putResult(result) {
+		gen.printIgnoredResult(result, "late response with full result sink")
+		return false
+	}
+	atomic.AddInt64(&gen.results.lateRecorded, 1)
+	return true
+}
+
+// 按背压策略把结果交给接收方，结果被丢弃时返回 false
+func (gen *myGenerator) putResult(result *lib.CallResult) bool {
+	switch gen.backpressure.Kind {
+	case lib.BACKPRESSURE_BLOCK:
+		return lib.PutContext(gen.sinkCtx, gen.sink, result)
+	case lib.BACKPRESSURE_SAMPLE:
+		if atomic.AddUint64(&gen.resultSeq, 1)%uint64(gen.backpressure.Every()) == 0 {
+			return lib.PutContext(gen.sinkCtx, gen.sink, result)
+		}
+	}
+	return gen.sink.TryPut(result)
 }
 
 // 打印被忽略的结果
 func (gen *myGenerator) printIgnoredResult(result *lib.CallResult, cause string) {
-	resultMsg := fmt.Sprintf("ID=%d, Code=%d, Msg=%s, Elapse=%v", result.ID, result.Code, result.Msg, result.Elapse)
+	resultMsg := fmt.Sprintf("ID=%d, Code=%d, Msg=%s, Elapse=%v, ResponseTime=%v",
+		result.ID, result.Code, result.Msg, result.Elapse, result.ResponseTime)
 	logger.Warnf("Ignored result: %s. (cause: %s)\n", resultMsg, cause)
 }
 
+// 根据上下文被取消的原因得出停止的原因
+func stopReasonOf(cause error) lib.StopReason {
+	var abortErr *abortError
+	switch {
+	case errors.As(cause, &abortErr):
+		return lib.StopReason{Kind: lib.STOP_REASON_ABORTED, Msg: abortErr.msg}
+	case errors.Is(cause, context.DeadlineExceeded):
+		return lib.StopReason{Kind: lib.STOP_REASON_DURATION, Msg: "Duration elapsed."}
+	default:
+		return lib.StopReason{Kind: lib.STOP_REASON_MANUAL, Msg: "Stopped manually."}
+	}
+}
+
 // 用于为停止载荷发生器做准备
 func (gen *myGenerator) prepareToStop(ctxError error) {
 	logger.Infof("Prepare to stop load generator (cause: %s)...", ctxError)
+	reason := stopReasonOf(ctxError)
+	if reason.Kind == lib.STOP_REASON_ABORTED {
+		logger.Warnf("Load generator aborted: %s", reason.Msg)
+	}
+	gen.stopReason.Store(&reason)
 	atomic.CompareAndSwapUint32(&gen.status, lib.STATUS_STARTED, lib.STATUS_STOPPING)
-	logger.Infof("Closing result channel...")
-	close(gen.resultCh)
+	atomic.CompareAndSwapUint32(&gen.status, lib.STATUS_PAUSED, lib.STATUS_STOPPING)
+	gen.runLock.Lock()
+	gen.budgetTimer.Stop()
+	gen.activeNS = gen.elapsedLocked()
+	gen.runLock.Unlock()
+	gen.drain()
+	logger.Infof("Closing result sink...")
+	gen.resultLock.Lock()
+	gen.resultClosed = true
+	gen.sink.Close()
+	gen.resultLock.Unlock()
 	atomic.StoreUint32(&gen.status, lib.STATUS_STOPPED)
 }
 
+// 等待正在进行的调用完成，超过排空期限后放弃剩余的调用
+func (gen *myGenerator) drain() {
+	if gen.drainNS > 0 {
+		logger.Infof("Draining in-flight calls (deadline: %v)...", gen.drainNS)
+		done := make(chan struct{})
+		go func() {
+			gen.inFlight.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			logger.Infoln("Drained.")
+		case <-time.After(gen.drainNS):
+			logger.Warnf("Drain deadline exceeded (%v).", gen.drainNS)
+		}
+	}
+	// 排空结束后不再等待跟不上的接收方，否则关闭接收方时会一直等待持有结果锁的发送
+	gen.sinkCancel()
+	// 先放弃再取消，否则被取消的调用会抢先以调用错误的形式发送结果
+	gen.abandonPending()
+	gen.callCancel()
+}
+
+// 载荷曲线给出零载荷时重新检查的间隔
+const idleIntervalNS = 10 * time.Millisecond
+
+// 发送循环一次最多成批发出的载荷数
+const maxBatchSize = 1024
+
 // 产生载荷并向承受方发送
-func (gen *myGenerator) genLoad(throttle <-chan time.Time) {
+// 发送时刻由按绝对时间表计划的节拍器给出，每次唤醒都会成批发出所有已到期的载荷；
+// 落后时不跳过载荷，而是按原来的计划时刻补发，以免响应时间漏掉排队的时间
+func (gen *myGenerator) genLoad() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	pacer := lib.NewPacer(gen.arrival, maxBatchSize)
+	due := make([]time.Time, 0, maxBatchSize)
+	var started bool // 节拍器是否已开始计划
+	// 停止时记下已到期但还未发出的载荷
+	stop := func() {
+		if started {
+			atomic.AddInt64(&gen.missedLoads, pacer.Overdue(time.Now()))
+		}
+		gen.prepareToStop(context.Cause(gen.ctx))
+	}
 	for {
 		select {
 		case <-gen.ctx.Done():
-			gen.prepareToStop(gen.ctx.Err())
+			stop()
 			return
 		default:
 		}
-		gen.asyncCall()
-		if gen.lps > 0 {
+		// 暂停时等待恢复，恢复后从当前时刻重新计划
+		if resumeCh := gen.pausedCh(); resumeCh != nil {
 			select {
-			case <-throttle:
+			case <-resumeCh:
+				started = false
+				continue
 			case <-gen.ctx.Done():
-				gen.prepareToStop(gen.ctx.Err())
+				gen.prepareToStop(context.Cause(gen.ctx))
 				return
 			}
 		}
+		now := time.Now()
+		var wait time.Duration
+		lps := gen.currentProfile().LPS(gen.elapsed())
+		if lps == 0 {
+			wait = idleIntervalNS
+			started = false
+		} else {
+			pacer.SetLPS(lps)
+			if !started {
+				pacer.Reset(now)
+				started = true
+			}
+			due = pacer.Due(now, due[:0])
+			sent := true
+			for i, intended := range due {
+				if !gen.asyncCall(intended) {
+					atomic.AddInt64(&gen.missedLoads, int64(len(due)-i))
+					sent = false
+					break
+				}
+			}
+			if len(due) > 0 {
+				gen.recordLag(pacer.Lag())
+			}
+			if !sent {
+				continue
+			}
+			wait = time.Until(pacer.Next())
+			if wait <= 0 {
+				// 仍有到期的载荷，继续成批发出
+				continue
+			}
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-gen.replanCh:
+		case <-gen.ctx.Done():
+			stop()
+			return
+		}
+	}
+}
+
+// 记录发送循环落后于时间表的时长
+func (gen *myGenerator) recordLag(lag time.Duration) {
+	atomic.StoreInt64(&gen.scheduleLag, int64(lag))
+	if int64(lag) > atomic.LoadInt64(&gen.maxScheduleLag) {
+		atomic.StoreInt64(&gen.maxScheduleLag, int64(lag))
 	}
 }
 
 func (gen *myGenerator) Start() bool {
+	return gen.start(gen.genLoad)
+}
+
+// 启动载荷发生器，并在新的 goroutine 中运行 loop 直到停止
+func (gen *myGenerator) start(loop func()) bool {
 	logger.Infoln("Starting load generator...")
 
 	// 检查是否具备可启动的状态，顺便设置状态为启动
+	// 结果的接收方在停止时已被关闭，因此停止之后不能再次启动
 	if !atomic.CompareAndSwapUint32(&gen.status, lib.STATUS_ORIGINAL, lib.STATUS_STARTING) {
-		if !atomic.CompareAndSwapUint32(&gen.status, lib.STATUS_STOPPED, lib.STATUS_STARTING) {
-			return false
+		if atomic.LoadUint32(&gen.status) == lib.STATUS_STOPPED {
+			logger.Warnln("Load generator can not be started again after stopping.")
 		}
+		return false
 	}
+	logger.Infof("Setting load profile (max lps: %d)...", gen.profile.MaxLPS())
 
-	// 设定节流阀
-	var throttle <-chan time.Time
-	if gen.lps > 0 {
-		interval := time.Duration(1e9 / gen.lps)
-		logger.Infof("Setting throttle (%v)...", interval)
-		throttle = time.Tick(interval)
-	}
+	// 初始化上下文和取消函数，持续时长用尽时以超时为由取消
+	gen.ctx, gen.cancelFunc = context.WithCancelCause(context.Background())
+	gen.callCtx, gen.callCancel = context.WithCancel(context.Background())
+	gen.sinkCtx, gen.sinkCancel = context.WithCancel(context.Background())
+	gen.runLock.Lock()
+	gen.remainingNS = gen.durationNs
+	gen.resumedAt = time.Now()
+	gen.activeNS = -1
+	gen.resumeCh = nil
+	gen.budgetTimer = time.AfterFunc(gen.durationNs, func() {
+		gen.cancelFunc(context.DeadlineExceeded)
+	})
+	gen.runLock.Unlock()
 
-	// 初始化上下文和取消函数。
-	gen.ctx, gen.cancelFunc = context.WithTimeout(context.Background(), gen.durationNs)
+	// 初始化中止策略和停止原因
+	gen.abort = nil
+	if gen.abortPolicy != nil {
+		gen.abort = newAbortMonitor(*gen.abortPolicy)
+	}
+	gen.stopReason.Store(nil)
 
-	// 初始化调用计数
-	gen.callCount = 0
+	// 初始化调用计数和统计
+	atomic.StoreInt64(&gen.callCount, 0)
+	atomic.StoreInt64(&gen.ticketWaits, 0)
+	atomic.StoreInt64(&gen.ticketWaitNS, 0)
+	atomic.StoreInt64(&gen.missedLoads, 0)
+	gen.results.reset()
+	atomic.StoreUint64(&gen.resultSeq, 0)
+	atomic.StoreInt64(&gen.scheduleLag, 0)
+	atomic.StoreInt64(&gen.maxScheduleLag, 0)
 
 	//设置状态为启动
 	atomic.StoreUint32(&gen.status, lib.STATUS_STARTED)
 
 	go func() {
 		logger.Infoln("Generating loads...")
-		gen.genLoad(throttle)
-		logger.Infof("Stoped.(call count: %d)", gen.callCount)
+		loop()
+		logger.Infof("Stoped.(call count: %d)", gen.CallCount())
 	}()
 
 	return true
@@ -251,9 +755,11 @@ func (gen *myGenerator) Status() uint32 {
 
 func (gen *myGenerator) Stop() bool {
 	if !atomic.CompareAndSwapUint32(&gen.status, lib.STATUS_STARTED, lib.STATUS_STOPPING) {
-		return false
+		if !atomic.CompareAndSwapUint32(&gen.status, lib.STATUS_PAUSED, lib.STATUS_STOPPING) {
+			return false
+		}
 	}
-	gen.cancelFunc()
+	gen.cancelFunc(nil)
 	for {
 		if atomic.LoadUint32(&gen.status) == lib.STATUS_STOPPED {
 			break
@@ -266,3 +772,98 @@ func (gen *myGenerator) Stop() bool {
 func (gen *myGenerator) CallCount() int64 {
 	return atomic.LoadInt64(&gen.callCount)
 }
+
+func (gen *myGenerator) Stats() lib.GenStats {
+	return lib.GenStats{
+		CallCount:      atomic.LoadInt64(&gen.callCount),
+		InFlight:       atomic.LoadInt64(&gen.inFlightNum),
+		Concurrency:    gen.tickets.Total(),
+		TicketWaits:    atomic.LoadInt64(&gen.ticketWaits),
+		TicketWaitNS:   time.Duration(atomic.LoadInt64(&gen.ticketWaitNS)),
+		MissedLoads:    atomic.LoadInt64(&gen.missedLoads),
+		ActiveNS:       gen.active(),
+		Results:        gen.results.snapshot(),
+		ScheduleLag:    time.Duration(atomic.LoadInt64(&gen.scheduleLag)),
+		MaxScheduleLag: time.Duration(atomic.LoadInt64(&gen.maxScheduleLag)),
+	}
+}
+
+func (gen *myGenerator) StopReason() lib.StopReason {
+	if reason := gen.stopReason.Load(); reason != nil {
+		return *reason
+	}
+	return lib.StopReason{Kind: lib.STOP_REASON_NONE}
+}
+
+// 在运行中调整载荷量，只在已启动或暂停时有效
+// 调整后按恒定的载荷量发送，原有的载荷曲线（爬坡、阶梯等）不再起作用
+func (gen *myGenerator) SetLPS(lps uint32) bool {
+	gen.runLock.Lock()
+	defer gen.runLock.Unlock()
+	if !gen.running() {
+		return false
+	}
+	profile, err := lib.NewConstantProfile(lps)
+	if err != nil {
+		logger.Warnf("Setting lps failing: %s", err)
+		return false
+	}
+	concurrency := gen.maxInFlight
+	if concurrency == 0 {
+		concurrency = gen.calcConcurrency(lps)
+		if !gen.tickets.SetTotal(concurrency) {
+			return false
+		}
+	}
+	gen.profileLock.Lock()
+	if !lib.IsConstantProfile(gen.profile) {
+		logger.Warnf("The load profile is replaced by the constant lps %d.", lps)
+	}
+	gen.profile = profile
+	gen.profileLock.Unlock()
+	logger.Infof("Set lps to %d. (concurrency=%d)", lps, concurrency)
+
+	// 通知发送循环立即按新的载荷量重新计划
+	gen.replan()
+	return true
+}
+
+// 判断载荷发生器是否已启动或暂停，须持有 runLock
+func (gen *myGenerator) running() bool {
+	status := atomic.LoadUint32(&gen.status)
+	return status == lib.STATUS_STARTED || status == lib.STATUS_PAUSED
+}
+
+func (gen *myGenerator) Pause() bool {
+	gen.runLock.Lock()
+	defer gen.runLock.Unlock()
+	if !atomic.CompareAndSwapUint32(&gen.status, lib.STATUS_STARTED, lib.STATUS_PAUSED) {
+		return false
+	}
+	// 暂停期间不消耗持续时长
+	gen.budgetTimer.Stop()
+	gen.remainingNS -= time.Since(gen.resumedAt)
+	if gen.remainingNS < 0 {
+		gen.remainingNS = 0
+	}
+	gen.resumeCh = make(chan struct{})
+	gen.replan()
+	logger.Infof("Paused. (remaining duration: %v)", gen.remainingNS)
+	return true
+}
+
+func (gen *myGenerator) Resume() bool {
+	gen.runLock.Lock()
+	defer gen.runLock.Unlock()
+	if !atomic.CompareAndSwapUint32(&gen.status, lib.STATUS_PAUSED, lib.STATUS_STARTED) {
+		return false
+	}
+	gen.resumedAt = time.Now()
+	gen.budgetTimer = time.AfterFunc(gen.remainingNS, func() {
+		gen.cancelFunc(context.DeadlineExceeded)
+	})
+	close(gen.resumeCh)
+	gen.resumeCh = nil
+	logger.Infof("Resumed. (remaining duration: %v)", gen.remainingNS)
+	return true
+}
@@ -1,8 +1,15 @@
 package lpstest
 
 import (
+	"context"
+	"errors"
 	loadgenlib "lpstest/lib"
+	"lpstest/stats"
 	helper "lpstest/testhelper"
+	"lpstest/threshold"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -130,3 +137,850 @@ func TestStop(t *testing.T) {
 	tps := float64(successCount) / float64(timeoutNS/1e9)
 	t.Logf("Loads per second: %d; Treatments per second: %f.\n", pset.LPS, tps)
 }
+
+// 会在调用时休眠一段时间的调用器，用于模拟响应缓慢的承受方
+type sleepCaller struct {
+	sleepNS   time.Duration
+	id        int64
+	failAfter int64 // 非 0 时，ID 大于它的调用都会失败，用于模拟崩溃的承受方
+}
+
+func (caller *sleepCaller) BuildRed() loadgenlib.RawReq {
+	id := atomic.AddInt64(&caller.id, 1)
+	return loadgenlib.RawReq{ID: id, Req: []byte(strconv.FormatInt(id, 10))}
+}
+
+func (caller *sleepCaller) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	time.Sleep(caller.sleepNS)
+	if caller.failAfter > 0 {
+		if id, _ := strconv.ParseInt(string(req), 10, 64); id > caller.failAfter {
+			return nil, errors.New("connection refused")
+		}
+	}
+	return req, nil
+}
+
+func (caller *sleepCaller) CheckResp(rawReq loadgenlib.RawReq, rawResp loadgenlib.RawResp) *loadgenlib.CallResult {
+	return &loadgenlib.CallResult{
+		ID:   rawResp.ID,
+		Req:  rawReq,
+		Resp: rawResp,
+		Code: loadgenlib.RET_CODE_SUCCESS,
+	}
+}
+
+// 统计结果通道中的结果数
+func countResults(resultCh chan *loadgenlib.CallResult) int64 {
+	var count int64
+	for range resultCh {
+		count++
+	}
+	return count
+}
+
+// 获取使用 sleepCaller 的测试参数，超时时间为 50 毫秒，排空期限为 1 秒
+func sleepParamSet(sleepNS time.Duration, lps uint32, durationNS time.Duration) ParamSet {
+	return ParamSet{
+		Caller:     &sleepCaller{sleepNS: sleepNS},
+		TimeoutNS:  50 * time.Millisecond,
+		LPS:        lps,
+		DurationNS: durationNS,
+		ResultCh:   make(chan *loadgenlib.CallResult, 1000),
+		DrainNS:    time.Second,
+	}
+}
+
+// 新建并启动载荷发生器，初始化失败时终止测试
+func startGenerator(tb testing.TB, pset ParamSet) loadgenlib.Generator {
+	tb.Helper()
+	gen, err := NewGenerator(pset)
+	if err != nil {
+		tb.Fatalf("Load generator initialization failing: %s\n", err)
+	}
+	gen.Start()
+	return gen
+}
+
+// 运行载荷发生器直到它停止，返回它和收到的结果数，pset 须使用结果通道
+func runGenerator(tb testing.TB, pset ParamSet) (loadgenlib.Generator, int64) {
+	tb.Helper()
+	gen := startGenerator(tb, pset)
+	return gen, countResults(pset.ResultCh)
+}
+
+func TestPauseAndResume(t *testing.T) {
+	pset := sleepParamSet(time.Millisecond, 100, time.Second)
+	gen, err := NewGenerator(pset)
+	if err != nil {
+		t.Fatalf("Load generator initialization failing: %s\n", err)
+	}
+	start := time.Now()
+	gen.Start()
+	time.AfterFunc(300*time.Millisecond, func() {
+		if !gen.Pause() {
+			t.Error("Pausing load generator failing!")
+		}
+		if status := gen.Status(); status != loadgenlib.STATUS_PAUSED {
+			t.Errorf("Inconsistent status: expected: %d, actual: %d", loadgenlib.STATUS_PAUSED, status)
+		}
+		time.AfterFunc(500*time.Millisecond, func() {
+			if !gen.Resume() {
+				t.Error("Resuming load generator failing!")
+			}
+		})
+	})
+	count := countResults(pset.ResultCh)
+	elapsed := time.Since(start)
+	t.Logf("Elapsed: %v, call count: %d, result count: %d.\n", elapsed, gen.CallCount(), count)
+	if elapsed < 1400*time.Millisecond {
+		t.Fatalf("The remaining duration was lost after pausing! (elapsed: %v)", elapsed)
+	}
+	// 实际发送载荷的时长不含暂停的时间
+	if active := gen.Stats().ActiveNS; active < time.Second || active > 1200*time.Millisecond {
+		t.Fatalf("Inconsistent active duration: expected: %v, actual: %v", time.Second, active)
+	}
+	if count != gen.CallCount() {
+		t.Fatalf("Inconsistent result count: expected: %d, actual: %d", gen.CallCount(), count)
+	}
+}
+
+func TestSetLPS(t *testing.T) {
+	pset := sleepParamSet(time.Millisecond, 100, 2*time.Second)
+	gen, err := NewGenerator(pset)
+	if err != nil {
+		t.Fatalf("Load generator initialization failing: %s\n", err)
+	}
+	if gen.SetLPS(400) {
+		t.Fatal("Setting lps should fail before starting!")
+	}
+	gen.Start()
+	time.AfterFunc(time.Second, func() {
+		if gen.SetLPS(loadgenlib.MAX_LPS + 1) {
+			t.Error("Setting too large lps should fail!")
+		}
+		if !gen.SetLPS(400) {
+			t.Error("Setting lps failing!")
+		}
+	})
+	count := countResults(pset.ResultCh)
+	if gen.SetLPS(100) {
+		t.Fatal("Setting lps should fail after stopping!")
+	}
+	t.Logf("Call count: %d, result count: %d, stats: %+v.\n", gen.CallCount(), count, gen.Stats())
+	if count < 400 {
+		t.Fatalf("The new lps did not take effect! (result count: %d)", count)
+	}
+	if count != gen.CallCount() {
+		t.Fatalf("Inconsistent result count: expected: %d, actual: %d", gen.CallCount(), count)
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	// 前 500 毫秒为 100 lps，之后为 300 lps
+	profile, err := loadgenlib.NewStepProfile(100, 200, 500*time.Millisecond, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pset := sleepParamSet(time.Millisecond, 0, time.Second)
+	pset.Profile = profile
+	gen, count := runGenerator(t, pset)
+	t.Logf("Call count: %d, result count: %d.\n", gen.CallCount(), count)
+	if count < 180 || count > 220 {
+		t.Fatalf("The load profile did not take effect! (expected: ~200, actual: %d)", count)
+	}
+	if count != gen.CallCount() {
+		t.Fatalf("Inconsistent result count: expected: %d, actual: %d", gen.CallCount(), count)
+	}
+
+	// 发送间隔不足 1 纳秒的载荷量无效
+	pset.Profile = nil
+	pset.LPS = loadgenlib.MAX_LPS + 1
+	pset.ResultCh = make(chan *loadgenlib.CallResult)
+	if _, err := NewGenerator(pset); err == nil {
+		t.Fatal("Load generator with too large lps should be invalid!")
+	}
+}
+
+func TestTicketSaturation(t *testing.T) {
+	pset := sleepParamSet(40*time.Millisecond, 200, time.Second)
+	pset.TimeoutNS = 100 * time.Millisecond
+	pset.MaxInFlight = 2
+	gen := startGenerator(t, pset)
+	collector := stats.NewCollector()
+	collector.Consume(pset.ResultCh)
+	summary := collector.Snapshot(pset.DurationNS)
+	count := summary.Count
+	genStats := gen.Stats()
+	t.Logf("Result count: %d, stats: %+v.\n", count, genStats)
+	t.Logf("Summary: %s", summary)
+	if genStats.Concurrency != pset.MaxInFlight {
+		t.Fatalf("Inconsistent concurrency: expected: %d, actual: %d", pset.MaxInFlight, genStats.Concurrency)
+	}
+	if genStats.TicketWaits == 0 || genStats.MissedLoads == 0 {
+		t.Fatalf("The saturated ticket pool was not reported! (stats: %+v)", genStats)
+	}
+	if genStats.InFlight != 0 {
+		t.Fatalf("Inconsistent in-flight count: expected: %d, actual: %d", 0, genStats.InFlight)
+	}
+	// 载荷在发送方排队，响应时间应明显长于服务时间
+	if summary.Response.P90 <= 2*summary.Service.P90 {
+		t.Fatalf("The queueing delay was omitted from response time! (service: %s, response: %s)",
+			summary.Service, summary.Response)
+	}
+	// 落后的载荷按原来的计划时刻补发，排队的时间随运行时间累积而不是被截断
+	if summary.Response.Max < pset.DurationNS/2 {
+		t.Fatalf("The overdue loads were skipped instead of being issued late! (response: %s)", summary.Response)
+	}
+	if count != gen.CallCount() {
+		t.Fatalf("Inconsistent result count: expected: %d, actual: %d", gen.CallCount(), count)
+	}
+}
+
+func TestThresholds(t *testing.T) {
+	pset := sleepParamSet(5*time.Millisecond, 200, time.Second)
+	pset.TimeoutNS = 100 * time.Millisecond
+	gen := startGenerator(t, pset)
+	collector := stats.NewCollector()
+	collector.Consume(pset.ResultCh)
+	thresholds := threshold.MustParseSet(
+		"p95 Elapse < 50ms",
+		"error rate of RET_CODE_ERROR_* < 1%",
+		"achieved LPS >= 90% of target",
+	)
+	verdict := thresholds.Evaluate(threshold.NewInput(collector, pset.DurationNS, float64(pset.LPS)))
+	t.Logf("Verdict:\n%s", verdict)
+	if err := verdict.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if reason := gen.StopReason(); reason.Kind != loadgenlib.STOP_REASON_DURATION {
+		t.Fatalf("Inconsistent stop reason: expected: %d, actual: %d (%s)", loadgenlib.STOP_REASON_DURATION, reason.Kind, reason)
+	}
+}
+
+func TestAbortPolicy(t *testing.T) {
+	cases := []struct {
+		name   string
+		caller *sleepCaller
+		policy loadgenlib.AbortPolicy
+	}{
+		{
+			"consecutive failures",
+			&sleepCaller{sleepNS: time.Millisecond, failAfter: 50},
+			loadgenlib.AbortPolicy{MaxConsecutiveFailures: 20},
+		},
+		{
+			"error ratio",
+			&sleepCaller{sleepNS: time.Millisecond, failAfter: 50},
+			loadgenlib.AbortPolicy{MaxErrorRatio: 0.5, Window: 40},
+		},
+		{
+			"timeout ratio",
+			&sleepCaller{sleepNS: 100 * time.Millisecond},
+			loadgenlib.AbortPolicy{MaxTimeoutRatio: 0.1, Window: 20},
+		},
+	}
+	for _, c := range cases {
+		pset := ParamSet{
+			Caller:      c.caller,
+			TimeoutNS:   20 * time.Millisecond,
+			LPS:         uint32(200),
+			DurationNS:  5 * time.Second,
+			ResultCh:    make(chan *loadgenlib.CallResult, 2000),
+			AbortPolicy: &c.policy,
+		}
+		gen, err := NewGenerator(pset)
+		if err != nil {
+			t.Fatalf("Load generator initialization failing: %s\n", err)
+		}
+		if reason := gen.StopReason(); reason.Kind != loadgenlib.STOP_REASON_NONE {
+			t.Fatalf("Inconsistent stop reason before start: %d (%s)", reason.Kind, reason)
+		}
+		start := time.Now()
+		gen.Start()
+		countResults(pset.ResultCh)
+		elapsed := time.Since(start)
+		reason := gen.StopReason()
+		t.Logf("Stop reason of %s: %s (elapsed: %v)", c.name, reason, elapsed)
+		if reason.Kind != loadgenlib.STOP_REASON_ABORTED {
+			t.Fatalf("Inconsistent stop reason of %s: expected: %d, actual: %d (%s)",
+				c.name, loadgenlib.STOP_REASON_ABORTED, reason.Kind, reason)
+		}
+		if elapsed > 2*time.Second {
+			t.Fatalf("The load generator of %s was not aborted early! (elapsed: %v)", c.name, elapsed)
+		}
+	}
+
+	pset := sleepParamSet(0, 200, time.Second)
+	pset.AbortPolicy = &loadgenlib.AbortPolicy{MaxErrorRatio: 2}
+	if _, err := NewGenerator(pset); err == nil {
+		t.Fatal("Invalid abort policy should be rejected!")
+	}
+}
+
+func TestVUGenerator(t *testing.T) {
+	pset := VUParamSet{
+		ParamSet:      sleepParamSet(10*time.Millisecond, 0, time.Second),
+		Users:         5,
+		ThinkNS:       10 * time.Millisecond,
+		ThinkJitterNS: 5 * time.Millisecond,
+	}
+	pset.TimeoutNS = 100 * time.Millisecond
+	gen, err := NewVUGenerator(pset)
+	if err != nil {
+		t.Fatalf("Load generator initialization failing: %s\n", err)
+	}
+	gen.Start()
+	time.AfterFunc(500*time.Millisecond, func() {
+		if users := gen.Users(); users != pset.Users {
+			t.Errorf("Inconsistent users: expected: %d, actual: %d", pset.Users, users)
+		}
+		if gen.SetLPS(100) {
+			t.Error("Setting lps should not be supported!")
+		}
+	})
+	collector := stats.NewCollector()
+	collector.Consume(pset.ResultCh)
+	summary := collector.Snapshot(pset.DurationNS)
+	t.Logf("Summary: %s", summary)
+	// 每个用户每秒约 50 次调用
+	if summary.Count < 150 || summary.Count > 300 {
+		t.Fatalf("Inconsistent result count: expected: 150~300, actual: %d", summary.Count)
+	}
+	if summary.Count != gen.CallCount() {
+		t.Fatalf("Inconsistent result count: expected: %d, actual: %d", gen.CallCount(), summary.Count)
+	}
+	if summary.Ratio(loadgenlib.RET_CODE_SUCCESS) != 1 {
+		t.Fatalf("Unexpected unsuccessful results: %s", summary)
+	}
+	if gen.Users() != 0 || gen.StopReason().Kind != loadgenlib.STOP_REASON_DURATION {
+		t.Fatalf("Inconsistent state after stopping: users=%d, stop reason=%s", gen.Users(), gen.StopReason())
+	}
+}
+
+func TestVUParamSet(t *testing.T) {
+	// 载荷量由虚拟用户数代替，其余参数与开放模型的检查一致
+	pset := VUParamSet{ParamSet: sleepParamSet(0, 0, time.Second), Users: 1}
+	if err := pset.Check(); err != nil {
+		t.Fatalf("Valid parameters should pass: %s", err)
+	}
+	pset.DrainNS = -1
+	pset.Sink = loadgenlib.NewChannelSink(pset.ResultCh)
+	pset.Users = 0
+	fields := make(map[string]bool)
+	for _, e := range pset.Errors() {
+		fields[e.Field] = true
+	}
+	if len(fields) != 3 || !fields["DrainNS"] || !fields["Sink"] || !fields["Users"] {
+		t.Fatalf("Inconsistent invalid parameters: %v", fields)
+	}
+
+	// 虚拟用户数与载荷量有相同的上限
+	pset = VUParamSet{ParamSet: sleepParamSet(0, 0, time.Second), Users: loadgenlib.MAX_LPS + 1}
+	if err := pset.Check(); err == nil {
+		t.Fatal("Too many users should be rejected!")
+	}
+	pset.UserProfile = hugeProfile{}
+	if err := pset.Check(); err == nil {
+		t.Fatal("User profile with too many users should be rejected!")
+	}
+}
+
+// 最大值超过上限的载荷曲线
+type hugeProfile struct{}
+
+func (hugeProfile) LPS(elapsed time.Duration) uint32 { return 1 }
+
+func (hugeProfile) MaxLPS() uint32 { return loadgenlib.MAX_LPS + 1 }
+
+func TestVUGeneratorRamp(t *testing.T) {
+	profile, err := loadgenlib.NewRampProfile(1, 10, 500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pset := VUParamSet{
+		ParamSet:    sleepParamSet(10*time.Millisecond, 0, 2*time.Second),
+		UserProfile: profile,
+	}
+	pset.TimeoutNS = 100 * time.Millisecond
+	pset.ResultCh = make(chan *loadgenlib.CallResult, 5000)
+	gen, err := NewVUGenerator(pset)
+	if err != nil {
+		t.Fatalf("Load generator initialization failing: %s\n", err)
+	}
+	if gen.SetUsers(2) {
+		t.Fatal("Setting users should fail before starting!")
+	}
+	gen.Start()
+	var rampedUsers, setUsers uint32
+	time.AfterFunc(800*time.Millisecond, func() {
+		rampedUsers = gen.Users()
+		gen.SetUsers(2)
+	})
+	time.AfterFunc(1200*time.Millisecond, func() {
+		setUsers = gen.Stats().Concurrency
+		gen.Stop()
+	})
+	count := countResults(pset.ResultCh)
+	t.Logf("Result count: %d, users: %d -> %d, stop reason: %s", count, rampedUsers, setUsers, gen.StopReason())
+	if rampedUsers != 10 || setUsers != 2 {
+		t.Fatalf("Inconsistent users: expected: 10 -> 2, actual: %d -> %d", rampedUsers, setUsers)
+	}
+	if count != gen.CallCount() {
+		t.Fatalf("Inconsistent result count: expected: %d, actual: %d", gen.CallCount(), count)
+	}
+	if gen.StopReason().Kind != loadgenlib.STOP_REASON_MANUAL {
+		t.Fatalf("Inconsistent stop reason: %s", gen.StopReason())
+	}
+}
+
+func TestArrivalProcess(t *testing.T) {
+	trace, err := loadgenlib.NewTraceArrival([]time.Duration{2 * time.Millisecond, 8 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		arrival loadgenlib.ArrivalProcess
+		lps     uint32
+		count   int64 // 期望的结果数
+	}{
+		{"poisson", loadgenlib.NewPoissonArrival(1), 200, 200},
+		{"trace", trace, 1, 200}, // 平均间隔 5ms，与载荷量无关
+	}
+	for _, c := range cases {
+		pset := sleepParamSet(time.Millisecond, c.lps, time.Second)
+		pset.Arrival = c.arrival
+		pset.MaxInFlight = 20
+		gen, count := runGenerator(t, pset)
+		t.Logf("Result count of %s: %d, stats: %+v.", c.name, count, gen.Stats())
+		if count < c.count*3/4 || count > c.count*5/4 {
+			t.Errorf("Inconsistent result count of %s: expected: about %d, actual: %d", c.name, c.count, count)
+		}
+	}
+}
+
+func TestHighRate(t *testing.T) {
+	const lps = 20000
+	pset := sleepParamSet(0, lps, time.Second)
+	pset.ResultCh = make(chan *loadgenlib.CallResult, lps)
+	pset.MaxInFlight = 1000
+	gen, count := runGenerator(t, pset)
+	stats := gen.Stats()
+	t.Logf("Result count: %d, stats: %+v.", count, stats)
+	if count < lps*9/10 || count > lps*11/10 {
+		t.Errorf("Inconsistent result count: expected: about %d, actual: %d", lps, count)
+	}
+	if stats.CallCount != count {
+		t.Errorf("Inconsistent call count: expected: %d, actual: %d", count, stats.CallCount)
+	}
+}
+
+// 以 100k LPS 运行载荷发生器，报告实际达到的载荷量和落后于时间表的时长
+func BenchmarkHighRate(b *testing.B) {
+	const lps = 100000
+	for i := 0; i < b.N; i++ {
+		pset := sleepParamSet(0, lps, time.Second)
+		pset.ResultCh = make(chan *loadgenlib.CallResult, lps)
+		pset.MaxInFlight = 10000
+		gen, err := NewGenerator(pset)
+		if err != nil {
+			b.Fatalf("Load generator initialization failing: %s\n", err)
+		}
+		start := time.Now()
+		gen.Start()
+		count := countResults(pset.ResultCh)
+		elapsed := time.Since(start)
+		stats := gen.Stats()
+		b.ReportMetric(float64(count)/elapsed.Seconds(), "lps")
+		b.ReportMetric(float64(stats.MaxScheduleLag.Microseconds()), "max-lag-µs")
+		b.ReportMetric(float64(stats.MissedLoads), "missed")
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	cases := []struct {
+		name string
+		bp   loadgenlib.Backpressure
+	}{
+		{"drop", loadgenlib.Backpressure{Kind: loadgenlib.BACKPRESSURE_DROP}},
+		{"block", loadgenlib.Backpressure{Kind: loadgenlib.BACKPRESSURE_BLOCK}},
+		{"sample", loadgenlib.Backpressure{Kind: loadgenlib.BACKPRESSURE_SAMPLE, SampleEvery: 5}},
+	}
+	for _, c := range cases {
+		// 结果通道只有一个缓冲，消费方每个结果耗时 2ms，跟不上 1000 LPS
+		resultCh := make(chan *loadgenlib.CallResult, 1)
+		pset := sleepParamSet(0, 1000, 500*time.Millisecond)
+		pset.ResultCh = nil
+		pset.Sink = loadgenlib.NewChannelSink(resultCh)
+		pset.Backpressure = c.bp
+		gen := startGenerator(t, pset)
+		var count int64
+		for range resultCh {
+			count++
+			time.Sleep(2 * time.Millisecond)
+		}
+		stats := gen.Stats()
+		t.Logf("Backpressure %s: results=%d, stats: %+v.", c.name, count, stats)
+		// 结果要么被接收，要么被计入丢弃数，不会悄无声息地丢失
+		if count+stats.Results.IgnoredFull != stats.CallCount {
+			t.Errorf("Results lost with %s: calls=%d, results=%d, dropped=%d",
+				c.name, stats.CallCount, count, stats.Results.IgnoredFull)
+		}
+		if stats.Results.Sent != count {
+			t.Errorf("Inconsistent sent count with %s: expected: %d, actual: %d", c.name, count, stats.Results.Sent)
+		}
+		switch c.bp.Kind {
+		case loadgenlib.BACKPRESSURE_DROP:
+			if stats.Results.IgnoredFull == 0 {
+				t.Errorf("Results should be dropped with %s.", c.name)
+			}
+		case loadgenlib.BACKPRESSURE_BLOCK:
+			if stats.Results.IgnoredFull != 0 {
+				t.Errorf("No result should be dropped with %s, but %d dropped.", c.name, stats.Results.IgnoredFull)
+			}
+		case loadgenlib.BACKPRESSURE_SAMPLE:
+			if stats.Results.IgnoredFull == 0 || count < stats.CallCount/5 {
+				t.Errorf("Every 5th result should be kept with %s: calls=%d, results=%d",
+					c.name, stats.CallCount, count)
+			}
+		}
+	}
+}
+
+func TestBlockedSinkStop(t *testing.T) {
+	// 没有人读取结果，等待接收方的发送会一直阻塞
+	pset := sleepParamSet(0, 100, 10*time.Second)
+	pset.ResultCh = nil
+	pset.Sink = loadgenlib.NewChannelSink(make(chan *loadgenlib.CallResult))
+	pset.Backpressure = loadgenlib.Backpressure{Kind: loadgenlib.BACKPRESSURE_BLOCK}
+	pset.DrainNS = 100 * time.Millisecond
+	gen := startGenerator(t, pset)
+	time.Sleep(100 * time.Millisecond)
+	stopped := make(chan struct{})
+	go func() {
+		gen.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stopping was blocked by the result sink!")
+	}
+	stats := gen.Stats()
+	if stats.Results.IgnoredFull == 0 || stats.Results.Sent != 0 {
+		t.Fatalf("Blocked results should be dropped after draining: %+v", stats.Results)
+	}
+	if gen.Start() {
+		t.Fatal("Load generator should not be started again after stopping!")
+	}
+}
+
+func TestResultStats(t *testing.T) {
+	// 每次调用都会超时，响应在超时之后才返回
+	pset := sleepParamSet(30*time.Millisecond, 100, 300*time.Millisecond)
+	pset.TimeoutNS = 10 * time.Millisecond
+	pset.RecordLate = true
+	gen := startGenerator(t, pset)
+	var count, late int64
+	for result := range pset.ResultCh {
+		if !result.Late {
+			count++
+			continue
+		}
+		// 迟到的结果带有真实的耗时和结果
+		late++
+		if result.Code != loadgenlib.RET_CODE_SUCCESS || result.Elapse < 30*time.Millisecond {
+			t.Errorf("Inconsistent late result: code=%d, elapse=%v", result.Code, result.Elapse)
+		}
+	}
+	stats := gen.Stats()
+	rs := stats.Results
+	t.Logf("Result count: %d, stats: %+v.", count, stats)
+	if rs.Sent != count || rs.Timeouts != count {
+		t.Errorf("Inconsistent result counts: results=%d, sent=%d, timeouts=%d", count, rs.Sent, rs.Timeouts)
+	}
+	// 排空期间所有迟到的响应都已返回
+	if rs.LateResponses != rs.Timeouts || rs.LateRecorded != late || late != rs.LateResponses {
+		t.Errorf("Inconsistent late responses: timeouts=%d, late=%d, recorded=%d, received=%d",
+			rs.Timeouts, rs.LateResponses, rs.LateRecorded, late)
+	}
+	if rs.Panics != 0 || rs.Abandoned != 0 || rs.Ignored() != 0 {
+		t.Errorf("Unexpected result counts: %+v", rs)
+	}
+	if !rs.Complete(stats.CallCount) {
+		t.Errorf("Results should be complete: calls=%d, results: %+v", stats.CallCount, rs)
+	}
+}
+
+// 支持上下文的调用器，调用会一直阻塞到上下文结束
+type blockCaller struct {
+	sleepCaller
+	mutex sync.Mutex
+	errs  []error // 已返回的调用的错误
+}
+
+func (caller *blockCaller) CallContext(ctx context.Context, req []byte) ([]byte, error) {
+	<-ctx.Done()
+	caller.mutex.Lock()
+	caller.errs = append(caller.errs, ctx.Err())
+	caller.mutex.Unlock()
+	return nil, ctx.Err()
+}
+
+// 获取已返回的调用的错误
+func (caller *blockCaller) returned() []error {
+	caller.mutex.Lock()
+	defer caller.mutex.Unlock()
+	return append([]error(nil), caller.errs...)
+}
+
+func TestContextCallerStop(t *testing.T) {
+	// 停止时正在进行的调用应立即中止，而不是等到超时
+	caller := &blockCaller{}
+	pset := ParamSet{
+		Caller:     caller,
+		TimeoutNS:  10 * time.Second,
+		LPS:        100,
+		DurationNS: 10 * time.Second,
+		ResultCh:   make(chan *loadgenlib.CallResult, 100),
+	}
+	gen := startGenerator(t, pset)
+	time.Sleep(200 * time.Millisecond)
+	start := time.Now()
+	if !gen.Stop() {
+		t.Fatal("Stopping load generator failing!")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Stopping took too long: %v", elapsed)
+	}
+	countResults(pset.ResultCh)
+	deadline := time.Now().Add(time.Second)
+	for gen.Stats().InFlight > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("In-flight calls were not aborted! (stats: %+v)", gen.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if len(caller.returned()) == 0 {
+		t.Fatal("No call was aborted!")
+	}
+	for _, err := range caller.returned() {
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Inconsistent error of the aborted call: expected: %v, actual: %v", context.Canceled, err)
+		}
+	}
+}
+
+func TestContextCallerTimeout(t *testing.T) {
+	// 调用因超时而中止时应被记为超时，而不是调用错误
+	caller := &blockCaller{}
+	pset := ParamSet{
+		Caller:     caller,
+		TimeoutNS:  10 * time.Millisecond,
+		LPS:        100,
+		DurationNS: 300 * time.Millisecond,
+		ResultCh:   make(chan *loadgenlib.CallResult, 100),
+		DrainNS:    time.Second,
+	}
+	gen := startGenerator(t, pset)
+	var count int64
+	for result := range pset.ResultCh {
+		count++
+		if result.Code != loadgenlib.RET_CODE_WARNING_CALL_TIMEOUT {
+			t.Errorf("Inconsistent result code: expected: %d, actual: %d (msg: %s)",
+				loadgenlib.RET_CODE_WARNING_CALL_TIMEOUT, result.Code, result.Msg)
+		}
+	}
+	stats := gen.Stats()
+	rs := stats.Results
+	t.Logf("Result count: %d, stats: %+v.", count, stats)
+	if count == 0 || rs.Timeouts != count || rs.LateResponses != 0 {
+		t.Errorf("Inconsistent result counts: results=%d, timeouts=%d, late=%d", count, rs.Timeouts, rs.LateResponses)
+	}
+	if !rs.Complete(stats.CallCount) {
+		t.Errorf("Results should be complete: calls=%d, results: %+v", stats.CallCount, rs)
+	}
+	// 调用在超时时即被中止
+	for _, err := range caller.returned() {
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Inconsistent error of the timed out call: expected: %v, actual: %v", context.DeadlineExceeded, err)
+		}
+	}
+}
+
+func TestDrain(t *testing.T) {
+	// 排空期限内未完成的调用被放弃，并以单独的结果代码报告
+	run := func(caller loadgenlib.Caller) (map[loadgenlib.RetCode]int64, loadgenlib.GenStats) {
+		pset := ParamSet{
+			Caller:     caller,
+			TimeoutNS:  10 * time.Second,
+			LPS:        100,
+			DurationNS: 300 * time.Millisecond,
+			ResultCh:   make(chan *loadgenlib.CallResult, 100),
+			DrainNS:    50 * time.Millisecond,
+		}
+		gen := startGenerator(t, pset)
+		codes := make(map[loadgenlib.RetCode]int64)
+		for result := range pset.ResultCh {
+			codes[result.Code]++
+			if result.Code == loadgenlib.RET_CODE_WARNING_CALL_ABANDONED && (result.Elapse <= 0 || result.ResponseTime < result.Elapse) {
+				t.Errorf("Inconsistent abandoned result: elapse=%v, response time=%v", result.Elapse, result.ResponseTime)
+			}
+		}
+		stats := gen.Stats()
+		t.Logf("Result codes: %v, stats: %+v.", codes, stats)
+		rs := stats.Results
+		if rs.Abandoned == 0 || codes[loadgenlib.RET_CODE_WARNING_CALL_ABANDONED] != rs.Abandoned {
+			t.Errorf("Inconsistent abandoned count: results=%d, stats=%d", codes[loadgenlib.RET_CODE_WARNING_CALL_ABANDONED], rs.Abandoned)
+		}
+		if codes[loadgenlib.RET_CODE_WARNING_CALL_TIMEOUT] != 0 || codes[loadgenlib.RET_CODE_ERROR_CALL] != 0 {
+			t.Errorf("Abandoned calls should not be reported as timeouts or errors! (codes: %v)", codes)
+		}
+		if !rs.Complete(stats.CallCount) {
+			t.Errorf("Results should be complete: calls=%d, results: %+v", stats.CallCount, rs)
+		}
+		return codes, stats
+	}
+
+	// 不支持上下文的调用在排空期限之后才返回
+	codes, _ := run(&sleepCaller{sleepNS: 200 * time.Millisecond})
+	if codes[loadgenlib.RET_CODE_SUCCESS] == 0 {
+		t.Errorf("Calls finished before the drain deadline should succeed! (codes: %v)", codes)
+	}
+	// 支持上下文的调用被放弃之后才被取消，不会以调用错误的形式抢先发送结果
+	_, stats := run(&blockCaller{})
+	if stats.Results.Abandoned != stats.CallCount || stats.Results.LateResponses != 0 {
+		t.Errorf("All calls should be abandoned without late responses: %+v", stats)
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	server := helper.NewTCPServer()
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("TCP Server startup failing: %s", err)
+	}
+	defer server.Close()
+	err := server.SetFaults(helper.Faults{
+		Latency:     helper.Latency{Kind: helper.LATENCY_UNIFORM, Min: time.Millisecond, Max: 5 * time.Millisecond},
+		WrongResult: 0.1,
+		ServerError: 0.1,
+		DropConn:    0.05,
+		MismatchID:  0.05,
+		Hang:        0.05,
+		Seed:        1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 每次调用使用独立的连接，因此每种故障都对应一种结果代码
+	sink := loadgenlib.NewMemorySink()
+	pset := ParamSet{
+		Caller:     helper.NewTCPComm(server.Addr().String()),
+		TimeoutNS:  200 * time.Millisecond,
+		LPS:        200,
+		DurationNS: time.Second,
+		Sink:       sink,
+		DrainNS:    time.Second,
+	}
+	startGenerator(t, pset)
+	<-sink.Done()
+	codes := sink.Codes()
+	fs := server.FaultStats()
+	t.Logf("Result codes: %v, fault stats: %+v", codes, fs)
+	if fs.Requests != sink.Count() {
+		t.Errorf("Inconsistent request count: results=%d, server=%d", sink.Count(), fs.Requests)
+	}
+	expected := map[loadgenlib.RetCode]int64{
+		loadgenlib.RET_CODE_ERROR_RESPONSE:       fs.WrongResults + fs.Mismatched,
+		loadgenlib.RET_CODE_ERROR_CALEE:          fs.ServerErrors,
+		loadgenlib.RET_CODE_ERROR_CALL:           fs.Dropped,
+		loadgenlib.RET_CODE_WARNING_CALL_TIMEOUT: fs.Hung,
+	}
+	for code, n := range expected {
+		if n == 0 {
+			t.Errorf("No fault injected for code %d!", code)
+		}
+		if codes[code] != n {
+			t.Errorf("Inconsistent count of code %d: expected: %d, actual: %d", code, n, codes[code])
+		}
+	}
+}
+
+func TestServerOverhead(t *testing.T) {
+	server := helper.NewTCPServer()
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("TCP Server startup failing: %s", err)
+	}
+	defer server.Close()
+	latency := 2 * time.Millisecond
+	if err := server.SetFaults(helper.Faults{Latency: helper.Latency{Kind: helper.LATENCY_FIXED, Mean: latency}}); err != nil {
+		t.Fatal(err)
+	}
+
+	collector := stats.NewCollector()
+	done := make(chan struct{})
+	pset := ParamSet{
+		Caller:     helper.NewTCPComm(server.Addr().String()),
+		TimeoutNS:  time.Second,
+		LPS:        200,
+		DurationNS: time.Second,
+		Sink:       loadgenlib.NewFuncSink(collector.Add, func() { close(done) }),
+		DrainNS:    time.Second,
+	}
+	startGenerator(t, pset)
+	<-done
+	summary := collector.Snapshot(pset.DurationNS)
+	// 响应可能在服务器记录它之前到达，关闭服务器以等待所有处理完成
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ss := server.Stats()
+	// 调用方测得的服务时间中，服务器之外的部分即为建立连接和网络传输等开销
+	overhead := summary.Service.Mean - ss.Total.Mean
+	t.Logf("Service time: %s", summary.Service)
+	t.Logf("Server time: %s (queueing: %s)", ss.Total, ss.Queueing)
+	t.Logf("Mean overhead: %v (%.1f%% of the service time)", overhead, float64(overhead)/float64(summary.Service.Mean)*100)
+	if ss.Requests != summary.Count || ss.Responses != summary.Count {
+		t.Errorf("Inconsistent request counts: results=%d, requests=%d, responses=%d", summary.Count, ss.Requests, ss.Responses)
+	}
+	if ss.Handling.Min < latency {
+		t.Errorf("Handling time should include the injected latency: %s", ss.Handling)
+	}
+	if overhead <= 0 || summary.Service.Min < ss.Total.Min {
+		t.Errorf("The service time should exceed the server time: service: %s, server: %s", summary.Service, ss.Total)
+	}
+}
+
+// 每次调用都先建立连接的调用器
+type connectCaller struct {
+	sleepCaller
+	connectNS time.Duration
+}
+
+func (caller *connectCaller) CallContext(ctx context.Context, req []byte) ([]byte, error) {
+	time.Sleep(caller.connectNS)
+	loadgenlib.RecordConnect(ctx, caller.connectNS)
+	return caller.Call(req, 0)
+}
+
+func TestConnectTime(t *testing.T) {
+	pset := sleepParamSet(time.Millisecond, 50, 200*time.Millisecond)
+	pset.Caller = &connectCaller{sleepCaller: sleepCaller{sleepNS: time.Millisecond}, connectNS: 20 * time.Millisecond}
+	startGenerator(t, pset)
+	var count int
+	for result := range pset.ResultCh {
+		count++
+		// 建立连接的耗时单独记录，不计入服务时间，但仍计入响应时间
+		if result.ConnectNS != 20*time.Millisecond || result.Elapse >= 20*time.Millisecond ||
+			result.ResponseTime < result.ConnectNS+result.Elapse {
+			t.Fatalf("Inconsistent times: connect=%v, elapse=%v, response time=%v",
+				result.ConnectNS, result.Elapse, result.ResponseTime)
+		}
+	}
+	if count == 0 {
+		t.Fatal("No result!")
+	}
+}
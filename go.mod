@@ -2,9 +2,10 @@ module lpstest
 
 go 1.21.1
 
-require golang.org/x/text v0.14.0
-
 require (
-	github.com/sirupsen/logrus v1.9.3 // indirect
-	golang.org/x/sys v0.5.0 // indirect
+	github.com/sirupsen/logrus v1.9.3
+	golang.org/x/text v0.14.0
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require golang.org/x/sys v0.5.0 // indirect
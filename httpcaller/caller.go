@@ -0,0 +1,361 @@
+package httpcaller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lpstest/lib"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// HTTP 调用器的配置
+type Config struct {
+	Method  string            // 请求方法，默认为 GET
+	URL     string            // 请求地址，可以使用模板
+	Headers map[string]string // 请求头，值可以使用模板
+	Body    string            // 请求体，可以使用模板
+
+	MaxIdleConnsPerHost int           // 每个主机保持的空闲连接数，默认为 100
+	MaxConnsPerHost     int           // 每个主机的最大连接数，为 0 时不限制
+	IdleConnTimeout     time.Duration // 空闲连接的超时时间，默认为 90 秒
+	DisableKeepAlives   bool          // 是否禁用长连接
+
+	InsecureSkipVerify bool        // 是否跳过服务端证书的校验
+	CAFile             string      // 用于校验服务端证书的 CA 证书文件
+	TLSConfig          *tls.Config // 自定义的 TLS 配置，优先于以上两项
+
+	Assert Assertion // 对响应的断言
+}
+
+// 对响应的断言，未通过时结果代码为 RET_CODE_ERROR_RESPONSE，
+// 但服务端错误（5xx）的结果代码为 RET_CODE_ERROR_CALEE
+type Assertion struct {
+	StatusCodes []int             // 期望的状态码，为空时要求 2xx
+	Headers     map[string]string // 期望的响应头，值是正则表达式
+	BodyRegexp  string            // 响应体需匹配的正则表达式
+	JSONPaths   map[string]string // 响应体中 JSON 路径（如 data.items.0.id）期望的值
+}
+
+// 模板中可用的数据
+type TemplateData struct {
+	ID   int64     // 请求 ID
+	Time time.Time // 构建请求的时刻
+}
+
+// 基于 HTTP 协议的调用器
+type HTTPCaller struct {
+	method     string
+	url        *template.Template
+	headers    map[string]*template.Template
+	body       *template.Template
+	client     *http.Client
+	statuses   map[int]bool
+	headerRegs map[string]*regexp.Regexp
+	bodyReg    *regexp.Regexp
+	jsonPaths  map[string]string
+	seq        int64
+}
+
+// 新建一个 HTTP 调用器，它同时实现了 lib.ContextCaller
+func NewHTTPCaller(cfg Config) (lib.Caller, error) {
+	caller, errMsgs := newHTTPCaller(cfg)
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		errMsgs = append(errMsgs, err.Error())
+	}
+	if errMsgs != nil {
+		return nil, errors.New(strings.Join(errMsgs, " "))
+	}
+	caller.client = &http.Client{Transport: newTransport(cfg, tlsConfig)}
+	return caller, nil
+}
+
+// 检查配置中的模板和断言，不会读取 CA 证书文件，也不会新建连接池
+func (cfg Config) Check() error {
+	if _, errMsgs := newHTTPCaller(cfg); errMsgs != nil {
+		return errors.New(strings.Join(errMsgs, " "))
+	}
+	return nil
+}
+
+// 根据配置中的模板和断言新建一个还没有客户端的调用器，同时返回所有错误信息
+func newHTTPCaller(cfg Config) (*HTTPCaller, []string) {
+	var errMsgs []string
+	caller := &HTTPCaller{
+		method:     strings.ToUpper(cfg.Method),
+		headers:    make(map[string]*template.Template),
+		statuses:   make(map[int]bool),
+		headerRegs: make(map[string]*regexp.Regexp),
+		jsonPaths:  cfg.Assert.JSONPaths,
+	}
+	if caller.method == "" {
+		caller.method = http.MethodGet
+	}
+	var err error
+	if cfg.URL == "" {
+		errMsgs = append(errMsgs, "Invalid URL!")
+	} else if caller.url, err = template.New("url").Parse(cfg.URL); err != nil {
+		errMsgs = append(errMsgs, fmt.Sprintf("Invalid URL template: %s!", err))
+	}
+	for key, value := range cfg.Headers {
+		tmpl, err := template.New(key).Parse(value)
+		if err != nil {
+			errMsgs = append(errMsgs, fmt.Sprintf("Invalid header template %q: %s!", key, err))
+			continue
+		}
+		caller.headers[key] = tmpl
+	}
+	if cfg.Body != "" {
+		if caller.body, err = template.New("body").Parse(cfg.Body); err != nil {
+			errMsgs = append(errMsgs, fmt.Sprintf("Invalid body template: %s!", err))
+		}
+	}
+	for _, code := range cfg.Assert.StatusCodes {
+		caller.statuses[code] = true
+	}
+	for key, expr := range cfg.Assert.Headers {
+		reg, err := regexp.Compile(expr)
+		if err != nil {
+			errMsgs = append(errMsgs, fmt.Sprintf("Invalid header assertion %q: %s!", key, err))
+			continue
+		}
+		caller.headerRegs[key] = reg
+	}
+	if cfg.Assert.BodyRegexp != "" {
+		if caller.bodyReg, err = regexp.Compile(cfg.Assert.BodyRegexp); err != nil {
+			errMsgs = append(errMsgs, fmt.Sprintf("Invalid body assertion: %s!", err))
+		}
+	}
+	if errMsgs == nil {
+		// 用示例数据试着构建一次请求，以便尽早发现执行模板时才会出现的错误
+		if _, err := caller.newRequest(TemplateData{ID: 1, Time: time.Now()}); err != nil {
+			errMsgs = append(errMsgs, fmt.Sprintf("Invalid request: %s!", err))
+		}
+	}
+	return caller, errMsgs
+}
+
+// 根据配置生成 TLS 配置
+func newTLSConfig(cfg Config) (*tls.Config, error) {
+	if cfg.TLSConfig != nil {
+		return cfg.TLSConfig, nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid CA file: %s!", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("Invalid CA file: no certificate found in %s!", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// 根据配置生成连接池
+func newTransport(cfg Config, tlsConfig *tls.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	if transport.MaxIdleConnsPerHost == 0 {
+		transport.MaxIdleConnsPerHost = 100
+	}
+	if transport.MaxIdleConns < transport.MaxIdleConnsPerHost {
+		transport.MaxIdleConns = transport.MaxIdleConnsPerHost
+	}
+	transport.MaxConnsPerHost = cfg.MaxConnsPerHost
+	if cfg.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = cfg.IdleConnTimeout
+	}
+	transport.DisableKeepAlives = cfg.DisableKeepAlives
+	transport.TLSClientConfig = tlsConfig
+	return transport
+}
+
+// 渲染模板
+func render(tmpl *template.Template, data TemplateData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// 用模板数据构建一个请求
+func (caller *HTTPCaller) newRequest(data TemplateData) (*http.Request, error) {
+	var body []byte
+	if caller.body != nil {
+		s, err := render(caller.body, data)
+		if err != nil {
+			return nil, err
+		}
+		body = []byte(s)
+	}
+	url, err := render(caller.url, data)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(caller.method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, tmpl := range caller.headers {
+		value, err := render(tmpl, data)
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(key, "Host") {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(key, value)
+	}
+	return req, nil
+}
+
+// 构建一个请求，请求内容是 HTTP/1.1 格式的完整请求（请求行中使用绝对地址）
+func (caller *HTTPCaller) BuildRed() lib.RawReq {
+	data := TemplateData{
+		ID:   atomic.AddInt64(&caller.seq, 1),
+		Time: time.Now(),
+	}
+	req, err := caller.newRequest(data)
+	if err != nil {
+		panic(err)
+	}
+	var buf bytes.Buffer
+	if err := req.WriteProxy(&buf); err != nil {
+		panic(err)
+	}
+	return lib.RawReq{ID: data.ID, Req: buf.Bytes()}
+}
+
+// 发起一次调用
+func (caller *HTTPCaller) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutNS)
+	defer cancel()
+	return caller.CallContext(ctx, req)
+}
+
+// 发起一次调用，返回的是 HTTP/1.1 格式的完整响应
+func (caller *HTTPCaller) CallContext(ctx context.Context, req []byte) ([]byte, error) {
+	httpReq, err := parseRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	httpResp, err := caller.client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	return httputil.DumpResponse(httpResp, true)
+}
+
+// 从请求内容中解析出请求，请求内容可以由 BuildRed 构建，也可以是重放的请求
+func parseRequest(req []byte) (*http.Request, error) {
+	httpReq, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(req)))
+	if err != nil {
+		return nil, err
+	}
+	// 由服务端格式解析出的请求需去掉 RequestURI 才能用于客户端
+	httpReq.RequestURI = ""
+	return httpReq, nil
+}
+
+// 检查响应
+func (caller *HTTPCaller) CheckResp(rawReq lib.RawReq, rawResp lib.RawResp) *lib.CallResult {
+	var result lib.CallResult
+	result.ID = rawResp.ID
+	result.Req = rawReq
+	result.Resp = rawResp
+	httpResp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(rawResp.Resp)), nil)
+	if err != nil {
+		result.Code = lib.RET_CODE_ERROR_RESPONSE
+		result.Msg = fmt.Sprintf("Incorrectly formatted Resp: %s!", err)
+		return &result
+	}
+	defer httpResp.Body.Close()
+	var body bytes.Buffer
+	if _, err := body.ReadFrom(httpResp.Body); err != nil {
+		result.Code = lib.RET_CODE_ERROR_RESPONSE
+		result.Msg = fmt.Sprintf("Incomplete Resp body: %s!", err)
+		return &result
+	}
+	if msg := caller.checkStatus(httpResp.StatusCode); msg != "" {
+		result.Code = lib.RET_CODE_ERROR_RESPONSE
+		if httpResp.StatusCode >= 500 {
+			result.Code = lib.RET_CODE_ERROR_CALEE
+		}
+		result.Msg = msg
+		return &result
+	}
+	for key, reg := range caller.headerRegs {
+		if value := httpResp.Header.Get(key); !reg.MatchString(value) {
+			result.Code = lib.RET_CODE_ERROR_RESPONSE
+			result.Msg = fmt.Sprintf("Unexpected header %s: %q (expected: %s)!", key, value, reg)
+			return &result
+		}
+	}
+	if caller.bodyReg != nil && !caller.bodyReg.Match(body.Bytes()) {
+		result.Code = lib.RET_CODE_ERROR_RESPONSE
+		result.Msg = fmt.Sprintf("Unexpected body (expected: %s)!", caller.bodyReg)
+		return &result
+	}
+	if msg := caller.checkJSONPaths(body.Bytes()); msg != "" {
+		result.Code = lib.RET_CODE_ERROR_RESPONSE
+		result.Msg = msg
+		return &result
+	}
+	result.Code = lib.RET_CODE_SUCCESS
+	result.Msg = fmt.Sprintf("Success. (%s)", httpResp.Status)
+	return &result
+}
+
+// 检查状态码，未通过时返回错误信息
+func (caller *HTTPCaller) checkStatus(code int) string {
+	if len(caller.statuses) == 0 {
+		if code >= 200 && code < 300 {
+			return ""
+		}
+		return fmt.Sprintf("Unexpected status code: %d (expected: 2xx)!", code)
+	}
+	if caller.statuses[code] {
+		return ""
+	}
+	return fmt.Sprintf("Unexpected status code: %d!", code)
+}
+
+// 检查 JSON 路径上的值，未通过时返回错误信息
+func (caller *HTTPCaller) checkJSONPaths(body []byte) string {
+	if len(caller.jsonPaths) == 0 {
+		return ""
+	}
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return fmt.Sprintf("Incorrectly formatted JSON body: %s!", err)
+	}
+	for path, expected := range caller.jsonPaths {
+		value, ok := lookupJSON(doc, path)
+		if !ok {
+			return fmt.Sprintf("Missing JSON path: %s!", path)
+		}
+		if actual := formatJSONValue(value); actual != expected {
+			return fmt.Sprintf("Unexpected value at JSON path %s: %s (expected: %s)!", path, actual, expected)
+		}
+	}
+	return ""
+}
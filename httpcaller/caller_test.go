@@ -0,0 +1,182 @@
+package httpcaller
+
+import (
+	"fmt"
+	"io"
+	"lpstest/lib"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// 用于测试的 HTTP 服务，回显请求体和请求头中的 X-Request-ID
+func newTestServer(tls bool) *httptest.Server {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-ID", r.Header.Get("X-Request-ID"))
+		fmt.Fprintf(w, `{"method":%q,"echo":%s,"items":[{"id":1},{"id":2}]}`, r.Method, body)
+	})
+	if tls {
+		return httptest.NewTLSServer(handler)
+	}
+	return httptest.NewServer(handler)
+}
+
+// 用给定的配置发起一次调用并检查响应
+func call(t *testing.T, cfg Config) *lib.CallResult {
+	caller, err := NewHTTPCaller(cfg)
+	if err != nil {
+		t.Fatalf("HTTP caller initialization failing: %s", err)
+	}
+	rawReq := caller.BuildRed()
+	resp, err := caller.Call(rawReq.Req, time.Second)
+	if err != nil {
+		t.Fatalf("HTTP call failing: %s", err)
+	}
+	return caller.CheckResp(rawReq, lib.RawResp{ID: rawReq.ID, Resp: resp})
+}
+
+func TestHTTPCaller(t *testing.T) {
+	server := newTestServer(false)
+	defer server.Close()
+
+	cfg := Config{
+		Method:  "post",
+		URL:     server.URL + "/echo?id={{.ID}}",
+		Headers: map[string]string{"X-Request-ID": "req-{{.ID}}"},
+		Body:    `{"id":{{.ID}}}`,
+		Assert: Assertion{
+			StatusCodes: []int{http.StatusOK},
+			Headers:     map[string]string{"X-Request-ID": `^req-\d+$`},
+			BodyRegexp:  `"echo":\{"id":1\}`,
+			JSONPaths:   map[string]string{"method": "POST", "items.1.id": "2"},
+		},
+	}
+	if result := call(t, cfg); result.Code != lib.RET_CODE_SUCCESS {
+		t.Fatalf("Inconsistent result code: expected: %d, actual: %d (%s)", lib.RET_CODE_SUCCESS, result.Code, result.Msg)
+	}
+
+	cfg.Assert.JSONPaths = map[string]string{"items.0.id": "2"}
+	if result := call(t, cfg); result.Code != lib.RET_CODE_ERROR_RESPONSE {
+		t.Fatalf("Inconsistent result code: expected: %d, actual: %d (%s)", lib.RET_CODE_ERROR_RESPONSE, result.Code, result.Msg)
+	}
+}
+
+func TestHTTPCallerStatus(t *testing.T) {
+	server := newTestServer(false)
+	defer server.Close()
+
+	cases := []struct {
+		path string
+		code lib.RetCode
+	}{
+		{"/ok", lib.RET_CODE_SUCCESS},
+		{"/fail", lib.RET_CODE_ERROR_CALEE},
+		{"/missing", lib.RET_CODE_ERROR_RESPONSE},
+	}
+	for _, c := range cases {
+		result := call(t, Config{URL: server.URL + c.path})
+		if result.Code != c.code {
+			t.Errorf("Inconsistent result code for %s: expected: %d, actual: %d (%s)", c.path, c.code, result.Code, result.Msg)
+		}
+	}
+}
+
+func TestHTTPCallerTLS(t *testing.T) {
+	server := newTestServer(true)
+	defer server.Close()
+
+	caller, err := NewHTTPCaller(Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("HTTP caller initialization failing: %s", err)
+	}
+	rawReq := caller.BuildRed()
+	if _, err := caller.Call(rawReq.Req, time.Second); err == nil {
+		t.Fatal("Calling a server with an unknown certificate should fail!")
+	}
+
+	result := call(t, Config{URL: server.URL, InsecureSkipVerify: true})
+	if result.Code != lib.RET_CODE_SUCCESS {
+		t.Fatalf("Inconsistent result code: expected: %d, actual: %d (%s)", lib.RET_CODE_SUCCESS, result.Code, result.Msg)
+	}
+}
+
+func TestHTTPCallerTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	caller, err := NewHTTPCaller(Config{URL: server.URL})
+	if err != nil {
+		t.Fatalf("HTTP caller initialization failing: %s", err)
+	}
+	rawReq := caller.BuildRed()
+	start := time.Now()
+	if _, err := caller.Call(rawReq.Req, 20*time.Millisecond); err == nil {
+		t.Fatal("The call should time out!")
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Fatalf("The call was not aborted after timeout! (elapsed: %v)", elapsed)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	_, err := NewHTTPCaller(Config{
+		Body:   "{{.ID",
+		Assert: Assertion{BodyRegexp: "("},
+	})
+	if err == nil {
+		t.Fatal("Invalid config should be rejected!")
+	}
+	t.Logf("Expected error: %s", err)
+
+	// 执行时才会出错的模板也应在新建时被拒绝
+	for _, cfg := range []Config{
+		{URL: "http://localhost/{{.Foo}}"},
+		{URL: "http://localhost/", Headers: map[string]string{"X-Foo": "{{.Foo}}"}},
+		{URL: "http://localhost/", Body: "{{.ID.Foo}}"},
+		{URL: "://{{.ID}}"},
+	} {
+		if _, err := NewHTTPCaller(cfg); err == nil {
+			t.Errorf("Invalid config should be rejected! (%+v)", cfg)
+		}
+	}
+}
+
+func TestHTTPCallerReplay(t *testing.T) {
+	server := newTestServer(false)
+	defer server.Close()
+
+	caller, err := NewHTTPCaller(Config{
+		Method: "post",
+		URL:    server.URL + "/echo",
+		Body:   `{"id":{{.ID}}}`,
+		Assert: Assertion{BodyRegexp: `"echo":\{"id":1\}`},
+	})
+	if err != nil {
+		t.Fatalf("HTTP caller initialization failing: %s", err)
+	}
+	rawReq := caller.BuildRed()
+	// 同一请求内容的副本不是由 BuildRed 构建的，需从请求内容中解析
+	for _, req := range [][]byte{rawReq.Req, append([]byte(nil), rawReq.Req...)} {
+		resp, err := caller.Call(req, time.Second)
+		if err != nil {
+			t.Fatalf("HTTP call failing: %s", err)
+		}
+		result := caller.CheckResp(rawReq, lib.RawResp{ID: rawReq.ID, Resp: resp})
+		if result.Code != lib.RET_CODE_SUCCESS {
+			t.Fatalf("Inconsistent result code: expected: %d, actual: %d (%s)", lib.RET_CODE_SUCCESS, result.Code, result.Msg)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package httpcaller
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 按以点分隔的路径查找 JSON 文档中的值，数组元素以下标表示，例如 data.items.0.id
+func lookupJSON(doc any, path string) (any, bool) {
+	current := doc
+	if path == "" {
+		return current, true
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]any:
+			value, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case []any:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			current = node[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// 把 JSON 值格式化为用于比较的字符串，字符串不带引号，其余的值使用 JSON 格式
+func formatJSONValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case nil:
+		return "null"
+	default:
+		bytes, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(bytes)
+	}
+}
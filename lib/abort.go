@@ -0,0 +1,25 @@
+package lib
+
+// 中止策略，任一条件满足时提前停止载荷发生器，值为 0 的条件不生效
+type AbortPolicy struct {
+	// 连续未成功的结果数的上限
+	MaxConsecutiveFailures uint32
+	// 滑动窗口中错误（RET_CODE_ERROR_* 和 RET_CODE_FATAL_CALL）所占比例的上限，在 0~1 之间
+	MaxErrorRatio float64
+	// 滑动窗口中超时（RET_CODE_WARNING_CALL_TIMEOUT）所占比例的上限，在 0~1 之间
+	MaxTimeoutRatio float64
+	// 滑动窗口包含的最近的结果数，为 0 时使用 DEFAULT_ABORT_WINDOW，
+	// 窗口填满之前不检查比例
+	Window uint32
+}
+
+// 滑动窗口的默认大小
+const DEFAULT_ABORT_WINDOW = 100
+
+// 获取滑动窗口的大小
+func (p *AbortPolicy) WindowSize() uint32 {
+	if p.Window == 0 {
+		return DEFAULT_ABORT_WINDOW
+	}
+	return p.Window
+}
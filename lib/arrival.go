@@ -0,0 +1,133 @@
+package lib
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 到达过程的接口，给出相邻两次载荷之间的间隔
+// 它只在载荷发生器的发送循环中使用，无需并发安全
+type ArrivalProcess interface {
+	// 载荷量为 lps 时，下一次载荷与上一次载荷之间的间隔
+	Next(lps uint32) time.Duration
+}
+
+// 恒定间隔的到达过程
+type constantArrival struct{}
+
+// 新建一个恒定间隔的到达过程，间隔为 1s / lps
+func NewConstantArrival() ArrivalProcess {
+	return constantArrival{}
+}
+
+func (constantArrival) Next(lps uint32) time.Duration {
+	return time.Duration(1e9 / lps)
+}
+
+// 泊松到达过程
+type poissonArrival struct {
+	rand *rand.Rand
+}
+
+// 新建一个泊松到达过程，间隔服从均值为 1s / lps 的指数分布，
+// 相同的 seed 会产生相同的间隔序列
+func NewPoissonArrival(seed int64) ArrivalProcess {
+	return &poissonArrival{rand: rand.New(rand.NewSource(seed))}
+}
+
+func (a *poissonArrival) Next(lps uint32) time.Duration {
+	return time.Duration(a.rand.ExpFloat64() * 1e9 / float64(lps))
+}
+
+// 均匀抖动的到达过程
+type uniformArrival struct {
+	jitter float64
+	rand   *rand.Rand
+}
+
+// 新建一个均匀抖动的到达过程，间隔均匀分布在 (1s / lps) * (1 ± jitter) 之间，
+// jitter 在 0~1 之间，相同的 seed 会产生相同的间隔序列
+func NewUniformArrival(jitter float64, seed int64) (ArrivalProcess, error) {
+	if jitter < 0 || jitter > 1 {
+		errMsg := fmt.Sprintf("Invalid uniform arrival! (jitter=%v)", jitter)
+		return nil, errors.New(errMsg)
+	}
+	return &uniformArrival{jitter: jitter, rand: rand.New(rand.NewSource(seed))}, nil
+}
+
+func (a *uniformArrival) Next(lps uint32) time.Duration {
+	factor := 1 + a.jitter*(2*a.rand.Float64()-1)
+	return time.Duration(factor * 1e9 / float64(lps))
+}
+
+// 回放记录的间隔的到达过程
+type traceArrival struct {
+	intervals []time.Duration
+	mean      time.Duration // 平均间隔
+	next      int
+}
+
+// 新建一个回放记录的间隔的到达过程，回放完毕后从头开始
+// 回放时忽略载荷量，但载荷曲线给出零载荷时载荷发生器仍会暂停发送
+func NewTraceArrival(intervals []time.Duration) (ArrivalProcess, error) {
+	if len(intervals) == 0 {
+		return nil, errors.New("Invalid trace arrival! (empty trace)")
+	}
+	var total time.Duration
+	for i, interval := range intervals {
+		if interval < 0 {
+			errMsg := fmt.Sprintf("Invalid trace arrival! (intervals[%d]=%v)", i, interval)
+			return nil, errors.New(errMsg)
+		}
+		total += interval
+	}
+	// 间隔全为 0 时发送循环会不停地发出载荷
+	if total <= 0 {
+		return nil, errors.New("Invalid trace arrival! (zero total interval)")
+	}
+	trace := make([]time.Duration, len(intervals))
+	copy(trace, intervals)
+	mean := total / time.Duration(len(trace))
+	if mean == 0 {
+		mean = 1
+	}
+	return &traceArrival{intervals: trace, mean: mean}, nil
+}
+
+func (a *traceArrival) Next(lps uint32) time.Duration {
+	interval := a.intervals[a.next]
+	a.next = (a.next + 1) % len(a.intervals)
+	return interval
+}
+
+// 读取记录的间隔，每行一个，可以是时长（如 1.5ms）或纳秒数，
+// 空行和以 # 开头的行会被忽略
+func ReadTrace(r io.Reader) ([]time.Duration, error) {
+	var intervals []time.Duration
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if ns, err := strconv.ParseInt(text, 10, 64); err == nil {
+			intervals = append(intervals, time.Duration(ns))
+			continue
+		}
+		interval, err := time.ParseDuration(text)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid interval %q at line %d!", text, line)
+		}
+		intervals = append(intervals, interval)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return intervals, nil
+}
@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 抽取 n 个间隔，返回均值和标准差（单位为纳秒）
+func sampleArrival(arrival ArrivalProcess, lps uint32, n int) (mean, stddev float64) {
+	var sum, sumSq float64
+	for i := 0; i < n; i++ {
+		v := float64(arrival.Next(lps))
+		sum += v
+		sumSq += v * v
+	}
+	mean = sum / float64(n)
+	return mean, math.Sqrt(sumSq/float64(n) - mean*mean)
+}
+
+func TestArrivalProcesses(t *testing.T) {
+	uniform, err := NewUniformArrival(0.5, 1)
+	if err != nil {
+		t.Fatalf("Uniform arrival initialization failing: %s", err)
+	}
+	cases := []struct {
+		name    string
+		arrival ArrivalProcess
+		stddev  float64 // 期望的标准差与均值之比
+	}{
+		{"constant", NewConstantArrival(), 0},
+		{"poisson", NewPoissonArrival(1), 1},
+		{"uniform", uniform, 0.5 / math.Sqrt(3)},
+	}
+	const lps = 1000
+	for _, c := range cases {
+		mean, stddev := sampleArrival(c.arrival, lps, 100000)
+		t.Logf("%s: mean=%v, stddev=%v", c.name, time.Duration(mean), time.Duration(stddev))
+		if math.Abs(mean-1e6)/1e6 > 0.02 {
+			t.Errorf("Inconsistent mean interval of %s: expected: %v, actual: %v", c.name, time.Millisecond, time.Duration(mean))
+		}
+		if math.Abs(stddev/mean-c.stddev) > 0.02 {
+			t.Errorf("Inconsistent stddev of %s: expected: %.3f, actual: %.3f (ratio to mean)", c.name, c.stddev, stddev/mean)
+		}
+	}
+	if _, err := NewUniformArrival(1.5, 1); err == nil {
+		t.Fatal("Invalid jitter should be rejected!")
+	}
+}
+
+func TestArrivalSeed(t *testing.T) {
+	a, b, c := NewPoissonArrival(42), NewPoissonArrival(42), NewPoissonArrival(43)
+	var same, different = true, false
+	for i := 0; i < 100; i++ {
+		x, y, z := a.Next(100), b.Next(100), c.Next(100)
+		same = same && x == y
+		different = different || x != z
+	}
+	if !same || !different {
+		t.Fatalf("The arrival process is not reproducible by seed! (same: %v, different: %v)", same, different)
+	}
+}
+
+func TestTraceArrival(t *testing.T) {
+	trace, err := ReadTrace(strings.NewReader("# recorded intervals\n1ms\n\n2500000\n500us\n"))
+	if err != nil {
+		t.Fatalf("Reading trace failing: %s", err)
+	}
+	arrival, err := NewTraceArrival(trace)
+	if err != nil {
+		t.Fatalf("Trace arrival initialization failing: %s", err)
+	}
+	expected := []time.Duration{time.Millisecond, 2500 * time.Microsecond, 500 * time.Microsecond, time.Millisecond}
+	for i, exp := range expected {
+		if actual := arrival.Next(1); actual != exp {
+			t.Fatalf("Inconsistent interval %d: expected: %v, actual: %v", i, exp, actual)
+		}
+	}
+	if _, err := ReadTrace(strings.NewReader("1ms\nsoon\n")); err == nil {
+		t.Fatal("Invalid trace should be rejected!")
+	}
+	if _, err := NewTraceArrival(nil); err == nil {
+		t.Fatal("Empty trace should be rejected!")
+	}
+	if _, err := NewTraceArrival([]time.Duration{0, 0}); err == nil {
+		t.Fatal("Trace with zero total interval should be rejected!")
+	}
+}
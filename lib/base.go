@@ -11,21 +11,31 @@ type CallResult struct {
 	Resp   RawResp
 	Code   RetCode
 	Msg    string
-	Elapse time.Duration
+	Elapse time.Duration // 服务时间，从实际发出调用算起，不含建立连接的耗时
+	// 调用中建立连接的耗时，调用器未记录时为 0
+	ConnectNS time.Duration
+	// 响应时间，从计划发送时刻算起
+	// 载荷未能按计划发出时，它包含了在发送方排队的时间
+	ResponseTime time.Duration
+	// 是否为迟到的结果，即超时或被放弃之后才返回的真实响应
+	// 同一调用已经以超时或放弃的结果发送过一次，统计时应与普通结果分开
+	Late bool
 }
 
 // 请求结构
 type RawReq struct {
-	ID  int64
-	Req []byte
+	ID       int64
+	Req      []byte
+	Intended time.Time // 按发送计划应发出请求的时刻，由载荷发生器设置
 }
 
 // 响应结构
 type RawResp struct {
-	ID     int64
-	Resp   []byte
-	Err    error
-	Elapse time.Duration
+	ID        int64
+	Resp      []byte
+	Err       error
+	Elapse    time.Duration
+	ConnectNS time.Duration // 建立连接的耗时，不计入 Elapse
 }
 
 // 结果代码的类型
@@ -36,25 +46,116 @@ const (
 	STATUS_ORIGINAL uint32 = iota
 	STATUS_STARTING
 	STATUS_STARTED
+	STATUS_PAUSED
 	STATUS_STOPPING
 	STATUS_STOPPED
 )
 
+// 载荷发生器的统计快照
+type GenStats struct {
+	CallCount   int64  // 已发出的调用数
+	InFlight    int64  // 正在进行的调用数
+	Concurrency uint32 // 允许同时进行的调用数
+	// 实际发送载荷的时长，不含暂停的时间和停止时排空的时间
+	ActiveNS time.Duration
+	// 调用结果的计数
+	Results ResultStats
+
+	// 以下几项只适用于开放模型，闭合模型的载荷发生器中总是为 0
+	TicketWaits  int64         // 因票池耗尽而等待的次数
+	TicketWaitNS time.Duration // 等待票的总时长
+	MissedLoads  int64         // 停止时已到期但未能发出的载荷数
+	// 发送循环最近一次成批发出载荷时落后于时间表的时长
+	ScheduleLag time.Duration
+	// 发送循环落后于时间表的最大时长
+	MaxScheduleLag time.Duration
+}
+
+// 调用结果的计数
+// 每次调用都恰好产生一个结果，它要么被交给接收方，要么因某个原因被忽略
+type ResultStats struct {
+	Sent      int64 // 已交给接收方的结果数
+	Timeouts  int64 // 超时的调用数
+	Panics    int64 // 发生了恐慌的调用数
+	Abandoned int64 // 停止时被放弃的调用数
+	// 超时或被放弃之后才返回的响应数，它们的结果已经以超时或放弃发送过
+	LateResponses int64
+	// 作为迟到的结果交给接收方的响应数，只在开启了记录迟到结果时非 0
+	LateRecorded int64
+	// 因载荷发生器已停止而忽略的结果数
+	IgnoredStopped int64
+	// 因接收方跟不上而忽略的结果数
+	IgnoredFull int64
+}
+
+// 被忽略的结果总数
+func (s ResultStats) Ignored() int64 {
+	return s.IgnoredStopped + s.IgnoredFull
+}
+
+// 判断在发出了 calls 次调用之后结果是否完整，即所有结果都已交给接收方
+// 只有在载荷发生器停止之后才有意义
+func (s ResultStats) Complete(calls int64) bool {
+	return s.Ignored() == 0 && s.Sent == calls
+}
+
+// 声明代表停止原因的常量
+const (
+	STOP_REASON_NONE     uint32 = iota // 尚未停止
+	STOP_REASON_DURATION               // 持续时长已用尽
+	STOP_REASON_MANUAL                 // 调用了 Stop 方法
+	STOP_REASON_ABORTED                // 触发了中止策略
+)
+
+// 载荷发生器停止的原因
+type StopReason struct {
+	Kind uint32 // 停止原因的种类
+	Msg  string // 详细说明
+}
+
+func (r StopReason) String() string {
+	return r.Msg
+}
+
 // 载荷发生器的接口
 type Generator interface {
+	// 启动载荷发生器，停止时结果的接收方会被关闭，因此只能启动一次
 	Start() bool
 	Stop() bool
 	Status() uint32
 	CallCount() int64
+	// 在运行中调整载荷量，会以恒定载荷替换掉原有的载荷曲线
+	// 只在已启动或暂停时有效，否则返回 false
+	SetLPS(lps uint32) bool
+	// 暂停发送载荷，不关闭结果通道，也不消耗持续时长
+	Pause() bool
+	// 从暂停中恢复，继续使用剩余的持续时长
+	Resume() bool
+	// 获取统计快照
+	Stats() GenStats
+	// 获取停止的原因，尚未停止时 Kind 为 STOP_REASON_NONE
+	StopReason() StopReason
+}
+
+// 闭合模型的载荷发生器，由固定数量的虚拟用户循环发起调用，
+// 载荷量取决于承受方的响应速度，因此不支持 SetLPS
+type VUGenerator interface {
+	Generator
+	// 在运行中调整虚拟用户数，会替换掉原有的用户数曲线
+	// 只在已启动或暂停时有效，否则返回 false
+	SetUsers(users uint32) bool
+	// 获取当前的虚拟用户数
+	Users() uint32
 }
 
 const (
-	RET_CODE_SUCCESS              RetCode = 0
-	RET_CODE_WARNING_CALL_TIMEOUT         = 1001 // 调用超时警告
-	RET_CODE_ERROR_CALL                   = 2001 // 调用错误
-	RET_CODE_ERROR_RESPONSE               = 2002 // 响应内容错误
-	RET_CODE_ERROR_CALEE                  = 2003 // 被动用方的内部错误
-	RET_CODE_FATAL_CALL                   = 3001 // 调用过程中发生了致命错误
+	RET_CODE_SUCCESS                RetCode = 0
+	RET_CODE_WARNING_CALL_TIMEOUT           = 1001 // 调用超时警告
+	RET_CODE_WARNING_CALL_ABANDONED         = 1002 // 停止时调用被放弃的警告
+	RET_CODE_ERROR_CALL                     = 2001 // 调用错误
+	RET_CODE_ERROR_RESPONSE                 = 2002 // 响应内容错误
+	RET_CODE_ERROR_CALEE                    = 2003 // 被动用方的内部错误
+	RET_CODE_FATAL_CALL                     = 3001 // 调用过程中发生了致命错误
 )
 
 func GetRetCodePlain(code RetCode) string {
@@ -64,6 +165,8 @@ func GetRetCodePlain(code RetCode) string {
 		codePlain = "Success"
 	case RET_CODE_WARNING_CALL_TIMEOUT:
 		codePlain = "Call Timeout Warning"
+	case RET_CODE_WARNING_CALL_ABANDONED:
+		codePlain = "Call Abandoned Warning"
 	case RET_CODE_ERROR_CALL:
 		codePlain = "Call Error"
 	case RET_CODE_ERROR_RESPONSE:
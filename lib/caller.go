@@ -1,6 +1,10 @@
 package lib
 
-import "time"
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
 
 // 调用器接口
 type Caller interface {
@@ -11,3 +15,32 @@ type Caller interface {
 	// 检查响应
 	CheckResp(rawReq RawReq, rawResp RawResp) *CallResult
 }
+
+// 支持上下文的调用器接口
+// 载荷发生器会优先使用它，以便在超时或停止时中止正在进行的调用
+type ContextCaller interface {
+	Caller
+	// 调用，上下文被取消时应尽快返回
+	CallContext(ctx context.Context, req []byte) ([]byte, error)
+}
+
+// 上下文中记录建立连接耗时的键
+type connectKey struct{}
+
+// 返回一个可以记录建立连接耗时的上下文，以及读取记录的函数
+// 载荷发生器用它把建立连接的耗时从服务时间中分离出来
+func WithConnectRecorder(ctx context.Context) (context.Context, func() time.Duration) {
+	var connectNS int64
+	ctx = context.WithValue(ctx, connectKey{}, &connectNS)
+	return ctx, func() time.Duration {
+		return time.Duration(atomic.LoadInt64(&connectNS))
+	}
+}
+
+// 记录调用中建立连接（或等待连接建立）的耗时，上下文不支持记录时忽略
+// 支持上下文的调用器在调用时需要建立连接时使用它
+func RecordConnect(ctx context.Context, elapsed time.Duration) {
+	if connectNS, ok := ctx.Value(connectKey{}).(*int64); ok {
+		atomic.AddInt64(connectNS, int64(elapsed))
+	}
+}
@@ -1,13 +1,19 @@
 package lib
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sync"
 )
 
 type GoTickets interface {
 	// 拿走一张票
 	Take()
+	// 尝试拿走一张票，没有剩余的票时立即返回 false
+	TryTake() bool
+	// 拿走一张票，上下文被取消时放弃等待并返回 false
+	TakeContext(ctx context.Context) bool
 	// 归还一张票
 	Return()
 	// 票池是否已被激活
@@ -16,12 +22,17 @@ type GoTickets interface {
 	Total() uint32
 	// 剩余的票数
 	Remainder() uint32
+	// 调整票的总数，已被拿走的票不受影响
+	SetTotal(total uint32) bool
 }
 
 type myGoTickets struct {
 	total    uint32        // 票的总数
-	ticketCh chan struct{} // 票的容器
+	taken    uint32        // 已被拿走的票数
+	waiting  uint32        // 正在等待拿票的数量
+	notifyCh chan struct{} // 有票归还或总数变化时会被关闭，用于唤醒等待者
 	active   bool          // 票池是否已被激活
+	mutex    sync.Mutex    // 票池的专用锁
 }
 
 // 新建一个 Goroutine 票池
@@ -41,26 +52,87 @@ func (gt *myGoTickets) init(total uint32) bool {
 	if total == 0 {
 		return false
 	}
-	ch := make(chan struct{}, total)
-	n := int(total)
-	for i := 0; i < n; i++ {
-		ch <- struct{}{}
-	}
-	gt.ticketCh = ch
+	gt.notifyCh = make(chan struct{})
 	gt.total = total
 	gt.active = true
 	return true
 }
 
 func (gt *myGoTickets) Take() {
-	<-gt.ticketCh
+	gt.TakeContext(context.Background())
+}
+
+func (gt *myGoTickets) TryTake() bool {
+	gt.mutex.Lock()
+	defer gt.mutex.Unlock()
+	if gt.taken < gt.total {
+		gt.taken++
+		return true
+	}
+	return false
+}
+
+func (gt *myGoTickets) TakeContext(ctx context.Context) bool {
+	for {
+		gt.mutex.Lock()
+		if gt.taken < gt.total {
+			gt.taken++
+			gt.mutex.Unlock()
+			return true
+		}
+		gt.waiting++
+		notifyCh := gt.notifyCh
+		gt.mutex.Unlock()
+		var done bool
+		select {
+		case <-notifyCh:
+		case <-ctx.Done():
+			done = true
+		}
+		gt.mutex.Lock()
+		gt.waiting--
+		gt.mutex.Unlock()
+		if done {
+			return false
+		}
+	}
 }
 
 func (gt *myGoTickets) Return() {
-	gt.ticketCh <- struct{}{}
+	gt.mutex.Lock()
+	defer gt.mutex.Unlock()
+	if gt.taken > 0 {
+		gt.taken--
+	}
+	gt.notify()
+}
+
+// 唤醒所有等待拿票的使用方，调用前需持有锁
+func (gt *myGoTickets) notify() {
+	if gt.waiting == 0 {
+		return
+	}
+	close(gt.notifyCh)
+	gt.notifyCh = make(chan struct{})
+}
+
+func (gt *myGoTickets) SetTotal(total uint32) bool {
+	if total == 0 {
+		return false
+	}
+	gt.mutex.Lock()
+	defer gt.mutex.Unlock()
+	if !gt.active {
+		return false
+	}
+	gt.total = total
+	gt.notify()
+	return true
 }
 
 func (gt *myGoTickets) Total() uint32 {
+	gt.mutex.Lock()
+	defer gt.mutex.Unlock()
 	return gt.total
 }
 
@@ -69,5 +141,10 @@ func (gt *myGoTickets) Active() bool {
 }
 
 func (gt *myGoTickets) Remainder() uint32 {
-	return uint32(len(gt.ticketCh))
+	gt.mutex.Lock()
+	defer gt.mutex.Unlock()
+	if gt.taken >= gt.total {
+		return 0
+	}
+	return gt.total - gt.taken
 }
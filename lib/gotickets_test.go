@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGoTicketsSetTotal(t *testing.T) {
+	gt, err := NewGoTickets(2)
+	if err != nil {
+		t.Fatalf("Goroutine ticket pool initialization failing: %s", err)
+	}
+	gt.Take()
+	gt.Take()
+	if r := gt.Remainder(); r != 0 {
+		t.Fatalf("Inconsistent remainder: expected: %d, actual: %d", 0, r)
+	}
+
+	// 扩容应唤醒正在等待的使用方
+	taken := make(chan struct{})
+	go func() {
+		gt.Take()
+		close(taken)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if !gt.SetTotal(3) {
+		t.Fatal("Setting total failing!")
+	}
+	select {
+	case <-taken:
+	case <-time.After(time.Second):
+		t.Fatal("Waiting taker was not woken up after growing the pool!")
+	}
+
+	// 缩容后需归还足够多的票才能再次拿票
+	if !gt.SetTotal(1) {
+		t.Fatal("Setting total failing!")
+	}
+	gt.Return()
+	gt.Return()
+	if r := gt.Remainder(); r != 0 {
+		t.Fatalf("Inconsistent remainder: expected: %d, actual: %d", 0, r)
+	}
+	gt.Return()
+	if r := gt.Remainder(); r != 1 {
+		t.Fatalf("Inconsistent remainder: expected: %d, actual: %d", 1, r)
+	}
+	if gt.SetTotal(0) {
+		t.Fatal("Setting total to zero should fail!")
+	}
+}
@@ -0,0 +1,122 @@
+package lib
+
+import (
+	"time"
+)
+
+// 按绝对时间表给出载荷发送时刻的节拍器
+// 每次载荷的计划时刻都从时间表的锚点算起，不受计时器唤醒延迟的影响；
+// 唤醒时会一次给出所有已到期的载荷，以便成批发出、追上时间表；
+// 落后再多也不会跳过载荷，补发的载荷保留原来的计划时刻，
+// 这样从计划时刻算起的响应时间就包含了载荷在发送方排队的时间
+// 它只在载荷发生器的发送循环中使用，无需并发安全
+type Pacer struct {
+	arrival  ArrivalProcess
+	constant bool // 是否为恒定间隔，此时按锚点精确计算计划时刻
+	maxBatch int  // 一次最多给出的载荷数
+	lps      uint32
+	anchor   time.Time     // 当前载荷量开始生效的计划时刻
+	count    int64         // 从锚点起已给出的载荷数
+	next     time.Time     // 下一次载荷的计划时刻
+	last     time.Time     // 上一次给出的载荷的计划时刻，重新计划后为零值
+	lag      time.Duration // 最近一次给出的载荷中最早的一个落后的时长
+}
+
+// 新建一个节拍器
+// maxBatch 限制了一次给出的载荷数，以便发送循环及时响应停止等信号
+func NewPacer(arrival ArrivalProcess, maxBatch int) *Pacer {
+	if arrival == nil {
+		arrival = NewConstantArrival()
+	}
+	if maxBatch <= 0 {
+		maxBatch = 1
+	}
+	_, constant := arrival.(constantArrival)
+	return &Pacer{
+		arrival:  arrival,
+		constant: constant,
+		maxBatch: maxBatch,
+	}
+}
+
+// 从 now 开始重新计划，第一次载荷立即到期
+func (p *Pacer) Reset(now time.Time) {
+	p.anchor = now
+	p.count = 0
+	p.next = now
+	p.last = time.Time{}
+	p.lag = 0
+}
+
+// 设置载荷量，从上一次载荷起按新的载荷量重新计划下一次载荷
+func (p *Pacer) SetLPS(lps uint32) {
+	if lps == p.lps {
+		return
+	}
+	p.lps = lps
+	if lps == 0 || p.last.IsZero() {
+		p.anchor = p.next
+		p.count = 0
+		return
+	}
+	p.anchor = p.last
+	p.count = 0
+	p.next = p.last
+	p.advance()
+}
+
+// 把截至 now 已到期的载荷的计划时刻追加到 due 中并返回，最多 maxBatch 个
+func (p *Pacer) Due(now time.Time, due []time.Time) []time.Time {
+	p.lag = 0
+	if p.lps == 0 {
+		return due
+	}
+	for n := 0; n < p.maxBatch && !p.next.After(now); n++ {
+		if n == 0 {
+			p.lag = now.Sub(p.next)
+		}
+		due = append(due, p.next)
+		p.last = p.next
+		p.advance()
+	}
+	return due
+}
+
+// 计划下一次载荷
+func (p *Pacer) advance() {
+	p.count++
+	if p.constant {
+		// 整数部分和余数分开计算，既不会溢出，也不会累积舍入误差
+		lps := int64(p.lps)
+		offset := (p.count/lps)*1e9 + (p.count%lps)*1e9/lps
+		p.next = p.anchor.Add(time.Duration(offset))
+		return
+	}
+	p.next = p.next.Add(p.arrival.Next(p.lps))
+}
+
+// 平均间隔，回放记录的间隔时与载荷量无关
+func (p *Pacer) mean() time.Duration {
+	if trace, ok := p.arrival.(*traceArrival); ok {
+		return trace.mean
+	}
+	return time.Duration(1e9 / p.lps)
+}
+
+// 下一次载荷的计划时刻
+func (p *Pacer) Next() time.Time {
+	return p.next
+}
+
+// 最近一次给出的载荷中最早的一个落后于时间表的时长
+func (p *Pacer) Lag() time.Duration {
+	return p.lag
+}
+
+// 截至 now 已到期但尚未给出的载荷数，间隔不均匀时按平均间隔估算
+func (p *Pacer) Overdue(now time.Time) int64 {
+	if p.lps == 0 || p.next.After(now) {
+		return 0
+	}
+	return int64(now.Sub(p.next)/p.mean()) + 1
+}
@@ -0,0 +1,164 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+// 测试用的时间起点
+var pacerEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestPacerSchedule(t *testing.T) {
+	// 1s 不能被 3 整除，按累加间隔计划时每秒会少 1ns
+	for _, lps := range []uint32{3, 30000, 100000} {
+		pacer := NewPacer(NewConstantArrival(), int(lps))
+		pacer.SetLPS(lps)
+		pacer.Reset(pacerEpoch)
+		var due []time.Time
+		var count int
+		var last time.Time
+		// 每 10ms 唤醒一次，共 10s
+		for step := 0; step <= 1000; step++ {
+			now := pacerEpoch.Add(time.Duration(step) * 10 * time.Millisecond)
+			due = pacer.Due(now, due[:0])
+			count += len(due)
+			if len(due) > 0 {
+				last = due[len(due)-1]
+			}
+		}
+		if expected := 10*int(lps) + 1; count != expected {
+			t.Errorf("Inconsistent due count (lps=%d): expected: %d, actual: %d", lps, expected, count)
+		}
+		// 最后一次载荷恰好在第 10s
+		if expected := pacerEpoch.Add(10 * time.Second); !last.Equal(expected) {
+			t.Errorf("Schedule drifted (lps=%d): expected: %v, actual: %v", lps, expected, last)
+		}
+	}
+}
+
+func TestPacerCatchUp(t *testing.T) {
+	pacer := NewPacer(NewConstantArrival(), 4)
+	pacer.SetLPS(1000)
+	pacer.Reset(pacerEpoch)
+	due := pacer.Due(pacerEpoch, nil)
+	if len(due) != 1 || !due[0].Equal(pacerEpoch) || pacer.Lag() != 0 {
+		t.Fatalf("The first load should be due immediately! (due=%v, lag=%v)", due, pacer.Lag())
+	}
+
+	// 晚醒 10ms 时，成批给出已到期的载荷，每批最多 4 个，计划时刻不变
+	now := pacerEpoch.Add(10 * time.Millisecond)
+	var all []time.Time
+	for batch := 0; ; batch++ {
+		due = pacer.Due(now, due[:0])
+		if len(due) == 0 {
+			break
+		}
+		if len(due) > 4 {
+			t.Fatalf("Batch size exceeded: %d", len(due))
+		}
+		if batch == 0 && pacer.Lag() != 9*time.Millisecond {
+			t.Errorf("Inconsistent lag: expected: %v, actual: %v", 9*time.Millisecond, pacer.Lag())
+		}
+		all = append(all, due...)
+	}
+	if len(all) != 10 {
+		t.Fatalf("Inconsistent catch-up count: expected: 10, actual: %d", len(all))
+	}
+	for i, intended := range all {
+		expected := pacerEpoch.Add(time.Duration(i+1) * time.Millisecond)
+		if !intended.Equal(expected) {
+			t.Errorf("Inconsistent intended time of load %d: expected: %v, actual: %v", i, expected, intended)
+		}
+	}
+}
+
+func TestPacerFallBehind(t *testing.T) {
+	pacer := NewPacer(NewConstantArrival(), 300)
+	pacer.SetLPS(1000)
+	pacer.Reset(pacerEpoch)
+	pacer.Due(pacerEpoch, nil)
+
+	// 落后 1s 时不跳过载荷，按原来的计划时刻补发所有落后的载荷
+	now := pacerEpoch.Add(time.Second)
+	if overdue := pacer.Overdue(now); overdue != 1000 {
+		t.Fatalf("Inconsistent overdue count: expected: 1000, actual: %d", overdue)
+	}
+	var all []time.Time
+	for due := pacer.Due(now, nil); len(due) > 0; due = pacer.Due(now, due[:0]) {
+		all = append(all, due...)
+	}
+	if len(all) != 1000 || !all[0].Equal(pacerEpoch.Add(time.Millisecond)) || !all[999].Equal(now) {
+		t.Fatalf("Overdue loads should keep their intended times! (count=%d)", len(all))
+	}
+	if overdue := pacer.Overdue(now); overdue != 0 {
+		t.Errorf("Inconsistent overdue count after catching up: expected: 0, actual: %d", overdue)
+	}
+	if !pacer.Next().Equal(now.Add(time.Millisecond)) {
+		t.Errorf("Inconsistent next time: expected: %v, actual: %v", now.Add(time.Millisecond), pacer.Next())
+	}
+}
+
+func TestPacerTraceOverdue(t *testing.T) {
+	arrival, err := NewTraceArrival([]time.Duration{5 * time.Millisecond, 15 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Trace arrival initialization failing: %s", err)
+	}
+	pacer := NewPacer(arrival, 10)
+	pacer.SetLPS(1)
+	pacer.Reset(pacerEpoch)
+	pacer.Due(pacerEpoch, nil)
+
+	// 回放记录的间隔时按记录的平均间隔估算，与载荷量无关
+	if overdue := pacer.Overdue(pacerEpoch.Add(time.Second)); overdue != 100 {
+		t.Fatalf("Inconsistent overdue count: expected: 100, actual: %d", overdue)
+	}
+}
+
+func TestPacerSetLPS(t *testing.T) {
+	pacer := NewPacer(NewConstantArrival(), 10)
+	pacer.SetLPS(1)
+	pacer.Reset(pacerEpoch)
+	pacer.Due(pacerEpoch, nil)
+
+	// 载荷量提高后不必等完原来的间隔，从上一次载荷起按新的间隔计划
+	pacer.SetLPS(100)
+	expected := pacerEpoch.Add(10 * time.Millisecond)
+	if !pacer.Next().Equal(expected) {
+		t.Fatalf("Inconsistent next time after setting lps: expected: %v, actual: %v", expected, pacer.Next())
+	}
+	if due := pacer.Due(pacerEpoch.Add(25*time.Millisecond), nil); len(due) != 2 {
+		t.Errorf("Inconsistent due count: expected: 2, actual: %d", len(due))
+	}
+
+	// 零载荷时不给出载荷
+	pacer.SetLPS(0)
+	if due := pacer.Due(pacerEpoch.Add(time.Second), nil); len(due) != 0 {
+		t.Errorf("No load should be due with zero lps, but %d due.", len(due))
+	}
+}
+
+func BenchmarkPacer(b *testing.B) {
+	arrivals := []struct {
+		name    string
+		arrival ArrivalProcess
+	}{
+		{"constant", NewConstantArrival()},
+		{"poisson", NewPoissonArrival(1)},
+	}
+	for _, a := range arrivals {
+		b.Run(a.name, func(b *testing.B) {
+			pacer := NewPacer(a.arrival, 1024)
+			pacer.SetLPS(100000)
+			pacer.Reset(pacerEpoch)
+			due := make([]time.Time, 0, 1024)
+			now := pacerEpoch
+			b.ResetTimer()
+			for n := 0; n < b.N; {
+				// 每次唤醒前进 1ms，约有 100 个载荷到期
+				now = now.Add(time.Millisecond)
+				due = pacer.Due(now, due[:0])
+				n += len(due)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// 载荷曲线的接口，给出随运行时间变化的目标载荷量
+type LoadProfile interface {
+	// 运行了 elapsed 时长之后的目标载荷量（每秒）
+	LPS(elapsed time.Duration) uint32
+	// 整条曲线中的最大载荷量，用于估算并发量
+	MaxLPS() uint32
+}
+
+// 载荷量的上限，超过它时相邻两次载荷的间隔不足 1 纳秒
+const MAX_LPS = 1e9
+
+// 恒定载荷
+type constantProfile struct {
+	lps uint32
+}
+
+// 新建一个恒定载荷曲线
+func NewConstantProfile(lps uint32) (LoadProfile, error) {
+	if lps == 0 || lps > MAX_LPS {
+		errMsg := fmt.Sprintf("Invalid constant profile! (lps=%d)", lps)
+		return nil, errors.New(errMsg)
+	}
+	return &constantProfile{lps: lps}, nil
+}
+
+func (p *constantProfile) LPS(elapsed time.Duration) uint32 {
+	return p.lps
+}
+
+func (p *constantProfile) MaxLPS() uint32 {
+	return p.lps
+}
+
+// 判断载荷曲线是否为恒定载荷
+func IsConstantProfile(profile LoadProfile) bool {
+	_, ok := profile.(*constantProfile)
+	return ok
+}
+
+// 线性爬坡载荷
+type rampProfile struct {
+	from   uint32
+	to     uint32
+	rampNS time.Duration
+}
+
+// 新建一个线性爬坡载荷曲线：
+// 在 rampNS 时长内从 from 线性变化到 to，之后保持 to 不变
+func NewRampProfile(from, to uint32, rampNS time.Duration) (LoadProfile, error) {
+	if rampNS <= 0 {
+		errMsg := fmt.Sprintf("Invalid ramp profile! (rampNS=%v)", rampNS)
+		return nil, errors.New(errMsg)
+	}
+	if (from == 0 && to == 0) || from > MAX_LPS || to > MAX_LPS {
+		errMsg := fmt.Sprintf("Invalid ramp profile! (from=%d, to=%d)", from, to)
+		return nil, errors.New(errMsg)
+	}
+	return &rampProfile{from: from, to: to, rampNS: rampNS}, nil
+}
+
+func (p *rampProfile) LPS(elapsed time.Duration) uint32 {
+	if elapsed <= 0 {
+		return p.from
+	}
+	if elapsed >= p.rampNS {
+		return p.to
+	}
+	ratio := float64(elapsed) / float64(p.rampNS)
+	lps := float64(p.from) + (float64(p.to)-float64(p.from))*ratio
+	return uint32(math.Round(lps))
+}
+
+func (p *rampProfile) MaxLPS() uint32 {
+	if p.from > p.to {
+		return p.from
+	}
+	return p.to
+}
+
+// 阶梯载荷
+type stepProfile struct {
+	start  uint32
+	step   uint32
+	holdNS time.Duration
+	steps  uint32
+}
+
+// 新建一个阶梯载荷曲线：
+// 从 start 开始，每隔 holdNS 增加 step，最多增加 steps 次，之后保持不变
+func NewStepProfile(start, step uint32, holdNS time.Duration, steps uint32) (LoadProfile, error) {
+	if holdNS <= 0 {
+		errMsg := fmt.Sprintf("Invalid step profile! (holdNS=%v)", holdNS)
+		return nil, errors.New(errMsg)
+	}
+	max := uint64(start) + uint64(step)*uint64(steps)
+	if max == 0 || max > MAX_LPS {
+		errMsg := fmt.Sprintf("Invalid step profile! (start=%d, step=%d, steps=%d)", start, step, steps)
+		return nil, errors.New(errMsg)
+	}
+	return &stepProfile{start: start, step: step, holdNS: holdNS, steps: steps}, nil
+}
+
+func (p *stepProfile) LPS(elapsed time.Duration) uint32 {
+	if elapsed <= 0 {
+		return p.start
+	}
+	n := uint64(elapsed / p.holdNS)
+	if n > uint64(p.steps) {
+		n = uint64(p.steps)
+	}
+	return p.start + p.step*uint32(n)
+}
+
+func (p *stepProfile) MaxLPS() uint32 {
+	return p.start + p.step*p.steps
+}
+
+// 尖峰载荷
+type spikeProfile struct {
+	base    uint32
+	peak    uint32
+	atNS    time.Duration
+	spikeNS time.Duration
+}
+
+// 新建一个尖峰载荷曲线：
+// 平时保持 base，在 atNS 时刻起的 spikeNS 时长内突增到 peak
+func NewSpikeProfile(base, peak uint32, atNS, spikeNS time.Duration) (LoadProfile, error) {
+	if peak == 0 || peak > MAX_LPS || base > MAX_LPS {
+		errMsg := fmt.Sprintf("Invalid spike profile! (base=%d, peak=%d)", base, peak)
+		return nil, errors.New(errMsg)
+	}
+	if atNS < 0 || spikeNS <= 0 {
+		errMsg := fmt.Sprintf("Invalid spike profile! (atNS=%v, spikeNS=%v)", atNS, spikeNS)
+		return nil, errors.New(errMsg)
+	}
+	return &spikeProfile{base: base, peak: peak, atNS: atNS, spikeNS: spikeNS}, nil
+}
+
+func (p *spikeProfile) LPS(elapsed time.Duration) uint32 {
+	if elapsed >= p.atNS && elapsed < p.atNS+p.spikeNS {
+		return p.peak
+	}
+	return p.base
+}
+
+func (p *spikeProfile) MaxLPS() uint32 {
+	if p.base > p.peak {
+		return p.base
+	}
+	return p.peak
+}
+
+// 正弦波动载荷
+type sineProfile struct {
+	base      uint32
+	amplitude uint32
+	periodNS  time.Duration
+}
+
+// 新建一个正弦波动载荷曲线：
+// 以 base 为中心、amplitude 为振幅、periodNS 为周期波动
+func NewSineProfile(base, amplitude uint32, periodNS time.Duration) (LoadProfile, error) {
+	if periodNS <= 0 {
+		errMsg := fmt.Sprintf("Invalid sine profile! (periodNS=%v)", periodNS)
+		return nil, errors.New(errMsg)
+	}
+	if base == 0 || amplitude > base || uint64(base)+uint64(amplitude) > MAX_LPS {
+		errMsg := fmt.Sprintf("Invalid sine profile! (base=%d, amplitude=%d)", base, amplitude)
+		return nil, errors.New(errMsg)
+	}
+	return &sineProfile{base: base, amplitude: amplitude, periodNS: periodNS}, nil
+}
+
+func (p *sineProfile) LPS(elapsed time.Duration) uint32 {
+	phase := 2 * math.Pi * float64(elapsed%p.periodNS) / float64(p.periodNS)
+	lps := float64(p.base) + float64(p.amplitude)*math.Sin(phase)
+	return uint32(math.Round(lps))
+}
+
+func (p *sineProfile) MaxLPS() uint32 {
+	return p.base + p.amplitude
+}
@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadProfiles(t *testing.T) {
+	ramp, err := NewRampProfile(100, 1100, 10*time.Second)
+	if err != nil {
+		t.Fatalf("Ramp profile initialization failing: %s", err)
+	}
+	step, err := NewStepProfile(100, 50, time.Second, 4)
+	if err != nil {
+		t.Fatalf("Step profile initialization failing: %s", err)
+	}
+	spike, err := NewSpikeProfile(100, 2000, 5*time.Second, time.Second)
+	if err != nil {
+		t.Fatalf("Spike profile initialization failing: %s", err)
+	}
+	if IsConstantProfile(ramp) || IsConstantProfile(step) || IsConstantProfile(spike) {
+		t.Fatal("Only constant profiles should be reported as constant!")
+	}
+	sine, err := NewSineProfile(1000, 500, 4*time.Second)
+	if err != nil {
+		t.Fatalf("Sine profile initialization failing: %s", err)
+	}
+	cases := []struct {
+		name    string
+		profile LoadProfile
+		elapsed time.Duration
+		lps     uint32
+	}{
+		{"ramp start", ramp, 0, 100},
+		{"ramp middle", ramp, 5 * time.Second, 600},
+		{"ramp end", ramp, 20 * time.Second, 1100},
+		{"step first", step, 500 * time.Millisecond, 100},
+		{"step third", step, 2500 * time.Millisecond, 200},
+		{"step last", step, time.Minute, 300},
+		{"spike before", spike, 4 * time.Second, 100},
+		{"spike inside", spike, 5500 * time.Millisecond, 2000},
+		{"spike after", spike, 6 * time.Second, 100},
+		{"sine zero", sine, 0, 1000},
+		{"sine peak", sine, time.Second, 1500},
+		{"sine trough", sine, 3 * time.Second, 500},
+	}
+	for _, c := range cases {
+		if lps := c.profile.LPS(c.elapsed); lps != c.lps {
+			t.Errorf("Inconsistent lps for %s: expected: %d, actual: %d", c.name, c.lps, lps)
+		}
+	}
+	if max := step.MaxLPS(); max != 300 {
+		t.Errorf("Inconsistent max lps of step profile: expected: %d, actual: %d", 300, max)
+	}
+	if max := sine.MaxLPS(); max != 1500 {
+		t.Errorf("Inconsistent max lps of sine profile: expected: %d, actual: %d", 1500, max)
+	}
+}
+
+func TestInvalidLoadProfiles(t *testing.T) {
+	if _, err := NewConstantProfile(0); err == nil {
+		t.Error("Constant profile with zero lps should be invalid!")
+	}
+	if _, err := NewConstantProfile(MAX_LPS + 1); err == nil {
+		t.Error("Constant profile with too large lps should be invalid!")
+	}
+	if _, err := NewStepProfile(1, MAX_LPS/2, time.Second, 3); err == nil {
+		t.Error("Step profile exceeding the maximum lps should be invalid!")
+	}
+	if _, err := NewRampProfile(10, 20, 0); err == nil {
+		t.Error("Ramp profile with zero duration should be invalid!")
+	}
+	if _, err := NewSineProfile(100, 200, time.Second); err == nil {
+		t.Error("Sine profile with amplitude greater than base should be invalid!")
+	}
+}
@@ -0,0 +1,248 @@
+package lib
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// 调用结果的接收方，载荷发生器会从多个 goroutine 并发地向它发送结果
+type ResultSink interface {
+	// 尝试立即接收一个结果，无法立即接收时返回 false
+	TryPut(result *CallResult) bool
+	// 接收一个结果，无法立即接收时等待
+	Put(result *CallResult)
+	// 载荷发生器停止、不会再有结果时调用，只会被调用一次
+	Close()
+}
+
+// 等待时可以被取消的接收方，载荷发生器停止时会以此放弃等待
+type ContextSink interface {
+	ResultSink
+	// 接收一个结果，无法立即接收时等待，上下文被取消时放弃并返回 false
+	PutContext(ctx context.Context, result *CallResult) bool
+}
+
+// 把结果交给接收方，无法立即接收时等待，未能接收时返回 false
+// 接收方未实现 ContextSink 时等待无法被取消
+func PutContext(ctx context.Context, sink ResultSink, result *CallResult) bool {
+	if ctxSink, ok := sink.(ContextSink); ok {
+		return ctxSink.PutContext(ctx, result)
+	}
+	sink.Put(result)
+	return true
+}
+
+// 声明代表背压策略的常量
+const (
+	BACKPRESSURE_DROP   uint32 = iota // 接收方跟不上时丢弃结果并计数
+	BACKPRESSURE_BLOCK                // 等待接收方，调用会因此占用票而拖慢发送
+	BACKPRESSURE_SAMPLE               // 每 SampleEvery 个结果中等待发送一个，其余的跟不上时丢弃
+)
+
+// 默认的采样间隔
+const DEFAULT_SAMPLE_EVERY = 10
+
+// 结果接收方跟不上时的处理策略
+type Backpressure struct {
+	Kind uint32
+	// 采样时每多少个结果中保证发送一个，为 0 时使用 DEFAULT_SAMPLE_EVERY
+	SampleEvery uint32
+}
+
+// 实际使用的采样间隔
+func (bp Backpressure) Every() uint32 {
+	if bp.SampleEvery == 0 {
+		return DEFAULT_SAMPLE_EVERY
+	}
+	return bp.SampleEvery
+}
+
+// 通道接收方
+type channelSink struct {
+	ch chan *CallResult
+}
+
+// 新建一个把结果发送到通道的接收方，关闭时会关闭通道
+func NewChannelSink(ch chan *CallResult) ResultSink {
+	return &channelSink{ch: ch}
+}
+
+func (s *channelSink) TryPut(result *CallResult) bool {
+	select {
+	case s.ch <- result:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *channelSink) Put(result *CallResult) {
+	s.ch <- result
+}
+
+func (s *channelSink) PutContext(ctx context.Context, result *CallResult) bool {
+	// 能立即接收时总是接收，即使上下文已被取消
+	if s.TryPut(result) {
+		return true
+	}
+	select {
+	case s.ch <- result:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *channelSink) Close() {
+	close(s.ch)
+}
+
+// 回调接收方
+type funcSink struct {
+	put     func(*CallResult)
+	onClose func()
+}
+
+// 新建一个对每个结果调用 put 的接收方，put 须是并发安全的
+// 关闭时调用 onClose，它可以为 nil
+func NewFuncSink(put func(*CallResult), onClose func()) ResultSink {
+	return &funcSink{put: put, onClose: onClose}
+}
+
+func (s *funcSink) TryPut(result *CallResult) bool {
+	s.put(result)
+	return true
+}
+
+func (s *funcSink) Put(result *CallResult) {
+	s.put(result)
+}
+
+func (s *funcSink) Close() {
+	if s.onClose != nil {
+		s.onClose()
+	}
+}
+
+// 扇出接收方
+type FanOutSink struct {
+	sinks   []ResultSink
+	dropped []int64 // 各接收方未能接收的结果数
+}
+
+// 新建一个把每个结果都发送给多个接收方的接收方
+// TryPut 会尝试所有接收方，至少一个接收时就返回 true，
+// 各接收方未能接收的结果数由 Dropped 给出
+func NewFanOutSink(sinks ...ResultSink) *FanOutSink {
+	return &FanOutSink{sinks: sinks, dropped: make([]int64, len(sinks))}
+}
+
+func (s *FanOutSink) TryPut(result *CallResult) bool {
+	ok := false
+	for i, sink := range s.sinks {
+		if sink.TryPut(result) {
+			ok = true
+			continue
+		}
+		atomic.AddInt64(&s.dropped[i], 1)
+	}
+	return ok
+}
+
+func (s *FanOutSink) Put(result *CallResult) {
+	for _, sink := range s.sinks {
+		sink.Put(result)
+	}
+}
+
+func (s *FanOutSink) PutContext(ctx context.Context, result *CallResult) bool {
+	ok := false
+	for i, sink := range s.sinks {
+		if PutContext(ctx, sink, result) {
+			ok = true
+			continue
+		}
+		atomic.AddInt64(&s.dropped[i], 1)
+	}
+	return ok
+}
+
+func (s *FanOutSink) Close() {
+	for _, sink := range s.sinks {
+		sink.Close()
+	}
+}
+
+// 获取各接收方未能接收的结果数，顺序与新建时的参数一致
+func (s *FanOutSink) Dropped() []int64 {
+	dropped := make([]int64, len(s.dropped))
+	for i := range s.dropped {
+		dropped[i] = atomic.LoadInt64(&s.dropped[i])
+	}
+	return dropped
+}
+
+// 内存中的聚合接收方
+type MemorySink struct {
+	mutex   sync.Mutex
+	results []*CallResult
+	codes   map[RetCode]int64
+	done    chan struct{}
+}
+
+// 新建一个在内存中保存并按结果代码计数的接收方
+func NewMemorySink() *MemorySink {
+	return &MemorySink{
+		codes: make(map[RetCode]int64),
+		done:  make(chan struct{}),
+	}
+}
+
+func (s *MemorySink) TryPut(result *CallResult) bool {
+	s.Put(result)
+	return true
+}
+
+func (s *MemorySink) Put(result *CallResult) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.results = append(s.results, result)
+	s.codes[result.Code]++
+}
+
+func (s *MemorySink) Close() {
+	close(s.done)
+}
+
+// 获取关闭时被关闭的通道，用于等待载荷发生器停止
+func (s *MemorySink) Done() <-chan struct{} {
+	return s.done
+}
+
+// 获取已接收的结果的副本
+func (s *MemorySink) Results() []*CallResult {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	results := make([]*CallResult, len(s.results))
+	copy(results, s.results)
+	return results
+}
+
+// 获取已接收的结果数
+func (s *MemorySink) Count() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return int64(len(s.results))
+}
+
+// 获取各结果代码的计数的副本
+func (s *MemorySink) Codes() map[RetCode]int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	codes := make(map[RetCode]int64, len(s.codes))
+	for code, n := range s.codes {
+		codes[code] = n
+	}
+	return codes
+}
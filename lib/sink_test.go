@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+func TestChannelSink(t *testing.T) {
+	ch := make(chan *CallResult, 1)
+	sink := NewChannelSink(ch)
+	if !sink.TryPut(&CallResult{ID: 1}) {
+		t.Fatal("Channel sink should accept a result when the channel is not full!")
+	}
+	if sink.TryPut(&CallResult{ID: 2}) {
+		t.Fatal("Channel sink should reject a result when the channel is full!")
+	}
+	if result := <-ch; result.ID != 1 {
+		t.Fatalf("Inconsistent result ID: expected: 1, actual: %d", result.ID)
+	}
+	sink.Put(&CallResult{ID: 3})
+	sink.Close()
+	var ids []int64
+	for result := range ch {
+		ids = append(ids, result.ID)
+	}
+	if len(ids) != 1 || ids[0] != 3 {
+		t.Fatalf("Inconsistent results after closing: %v", ids)
+	}
+}
+
+func TestFanOutSink(t *testing.T) {
+	var count, closed int64
+	callback := NewFuncSink(func(*CallResult) {
+		atomic.AddInt64(&count, 1)
+	}, func() {
+		atomic.AddInt64(&closed, 1)
+	})
+	memory := NewMemorySink()
+	ch := make(chan *CallResult, 1)
+	sink := NewFanOutSink(callback, memory, NewChannelSink(ch))
+
+	if !sink.TryPut(&CallResult{ID: 1, Code: RET_CODE_SUCCESS}) {
+		t.Fatal("Fan-out sink should accept a result when all sinks accept it!")
+	}
+	// 通道已满时其他接收方仍会收到结果，只有通道计入未接收的结果
+	if !sink.TryPut(&CallResult{ID: 2, Code: RET_CODE_ERROR_CALL}) {
+		t.Fatal("Fan-out sink should accept a result when any sink accepts it!")
+	}
+	if dropped := sink.Dropped(); dropped[0] != 0 || dropped[1] != 0 || dropped[2] != 1 {
+		t.Fatalf("Inconsistent dropped counts: %v", dropped)
+	}
+	<-ch
+	sink.Put(&CallResult{ID: 3, Code: RET_CODE_SUCCESS})
+	sink.Close()
+
+	if count != 3 {
+		t.Errorf("Inconsistent callback count: expected: 3, actual: %d", count)
+	}
+	if closed != 1 {
+		t.Errorf("Callback sink should be closed once, but %d times.", closed)
+	}
+	if n := memory.Count(); n != 3 {
+		t.Errorf("Inconsistent memory sink count: expected: 3, actual: %d", n)
+	}
+	codes := memory.Codes()
+	if codes[RET_CODE_SUCCESS] != 2 || codes[RET_CODE_ERROR_CALL] != 1 {
+		t.Errorf("Inconsistent code counts: %v", codes)
+	}
+	if results := memory.Results(); results[2].ID != 3 {
+		t.Errorf("Inconsistent result order: %v", results)
+	}
+	select {
+	case <-memory.Done():
+	default:
+		t.Error("Memory sink should be done after closing!")
+	}
+	if result := <-ch; result.ID != 3 {
+		t.Errorf("Inconsistent result ID: expected: 3, actual: %d", result.ID)
+	}
+	if _, ok := <-ch; ok {
+		t.Error("Channel should be closed after closing the fan-out sink!")
+	}
+}
+
+func TestPutContext(t *testing.T) {
+	ch := make(chan *CallResult)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// 通道跟不上时，等待会随上下文被取消而放弃
+	if PutContext(ctx, NewChannelSink(ch), &CallResult{ID: 1}) {
+		t.Fatal("Putting to a blocked channel sink should be canceled!")
+	}
+	sink := NewFanOutSink(NewMemorySink(), NewChannelSink(ch))
+	if !PutContext(ctx, sink, &CallResult{ID: 2}) {
+		t.Fatal("Fan-out sink should accept a result when any sink accepts it!")
+	}
+	if dropped := sink.Dropped(); dropped[0] != 0 || dropped[1] != 1 {
+		t.Fatalf("Inconsistent dropped counts: %v", dropped)
+	}
+}
@@ -14,25 +14,92 @@ type ParamSet struct {
 	TimeoutNS  time.Duration
 	LPS        uint32
 	DurationNS time.Duration
-	ResultCh   chan *lib.CallResult
+	// 结果通道，停止时会被关闭，与 Sink 只能指定一个
+	ResultCh chan *lib.CallResult
+	// 结果的接收方，停止时会被关闭，与 ResultCh 只能指定一个
+	Sink lib.ResultSink
+	// 结果的接收方跟不上时的处理策略，默认丢弃并计数
+	Backpressure lib.Backpressure
+	// 是否把超时或被放弃之后才返回的响应作为迟到的结果（CallResult.Late）发送
+	RecordLate bool
+	// 载荷曲线，为 nil 时按 LPS 恒定发送
+	Profile lib.LoadProfile
+	// 到达过程，决定相邻两次载荷的间隔，为 nil 时间隔恒定
+	// 间隔不均匀时并发量会有突发，可能需要同时设置 MaxInFlight
+	Arrival lib.ArrivalProcess
+	// 停止时等待正在进行的调用完成的期限，为 0 时立即放弃它们
+	DrainNS time.Duration
+	// 允许同时进行的调用数，为 0 时根据超时时间和载荷量估算
+	MaxInFlight uint32
+	// 中止策略，为 nil 时不会因调用失败而提前停止
+	AbortPolicy *lib.AbortPolicy
 }
 
-func (pset *ParamSet) Check() error {
-	var errMsgs []string
+// 代表一个未通过检查的参数
+type ParamError struct {
+	Field string // 参数在 ParamSet 中的字段名
+	Msg   string // 错误信息
+}
+
+// 逐项检查参数，返回所有未通过检查的参数
+func (pset *ParamSet) Errors() []ParamError {
+	var errs []ParamError
 	if pset.Caller == nil {
-		errMsgs = append(errMsgs, "Invalid caller!")
+		errs = append(errs, ParamError{"Caller", "Invalid caller!"})
 	}
 	if pset.TimeoutNS == 0 {
-		errMsgs = append(errMsgs, "Invalid timeoutNS!")
+		errs = append(errs, ParamError{"TimeoutNS", "Invalid timeoutNS!"})
 	}
-	if pset.LPS == 0 {
-		errMsgs = append(errMsgs, "Invalid lps(load per second)!")
+	if pset.Profile == nil {
+		if pset.LPS == 0 || pset.LPS > lib.MAX_LPS {
+			errs = append(errs, ParamError{"LPS", "Invalid lps(load per second)!"})
+		}
+	} else if max := pset.Profile.MaxLPS(); max == 0 || max > lib.MAX_LPS {
+		errs = append(errs, ParamError{"Profile", "Invalid load profile!"})
 	}
 	if pset.DurationNS == 0 {
-		errMsgs = append(errMsgs, "Invalid durationNS!")
+		errs = append(errs, ParamError{"DurationNS", "Invalid durationNS!"})
+	}
+	if pset.DrainNS < 0 {
+		errs = append(errs, ParamError{"DrainNS", "Invalid drainNS!"})
+	}
+	errs = append(errs, checkSink(pset.ResultCh, pset.Sink, pset.Backpressure)...)
+	if policy := pset.AbortPolicy; policy != nil {
+		if policy.MaxErrorRatio < 0 || policy.MaxErrorRatio > 1 ||
+			policy.MaxTimeoutRatio < 0 || policy.MaxTimeoutRatio > 1 {
+			errs = append(errs, ParamError{"AbortPolicy", "Invalid abort policy!"})
+		}
+	}
+	return errs
+}
+
+// 检查结果的接收方和背压策略
+func checkSink(resultCh chan *lib.CallResult, sink lib.ResultSink, bp lib.Backpressure) []ParamError {
+	var errs []ParamError
+	switch {
+	case resultCh == nil && sink == nil:
+		errs = append(errs, ParamError{"ResultCh", "Invalid result channel!"})
+	case resultCh != nil && sink != nil:
+		errs = append(errs, ParamError{"Sink", "Invalid result sink! (result channel is specified too)"})
 	}
-	if pset.ResultCh == nil {
-		errMsgs = append(errMsgs, "Invalid result channel!")
+	if bp.Kind > lib.BACKPRESSURE_SAMPLE {
+		errs = append(errs, ParamError{"Backpressure", fmt.Sprintf("Invalid backpressure policy %d!", bp.Kind)})
+	}
+	return errs
+}
+
+// 获取结果的接收方，未指定时把结果通道包装为接收方
+func resultSink(resultCh chan *lib.CallResult, sink lib.ResultSink) lib.ResultSink {
+	if sink != nil {
+		return sink
+	}
+	return lib.NewChannelSink(resultCh)
+}
+
+func (pset *ParamSet) Check() error {
+	var errMsgs []string
+	for _, e := range pset.Errors() {
+		errMsgs = append(errMsgs, e.Msg)
 	}
 	var buf bytes.Buffer
 	buf.WriteString("Checking the parameters...")
@@ -42,7 +109,7 @@ func (pset *ParamSet) Check() error {
 		logger.Infoln(buf.String())
 		return errors.New(errMsg)
 	}
-	buf.WriteString(fmt.Sprintf("Passed. (timeoutNS=%s, lps=%d, durationNS=%s)", pset.TimeoutNS, pset.LPS, pset.DurationNS))
+	buf.WriteString(fmt.Sprintf("Passed. (timeoutNS=%s, lps=%d, durationNS=%s, maxInFlight=%d)", pset.TimeoutNS, pset.LPS, pset.DurationNS, pset.MaxInFlight))
 	logger.Infoln(buf.String())
 	return nil
 }
@@ -0,0 +1,187 @@
+package plan
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"lpstest"
+	"lpstest/lib"
+	"lpstest/threshold"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 测试计划，可以用 YAML 或 JSON 文件描述
+type Plan struct {
+	Caller     CallerSpec    `yaml:"caller"`     // 调用器
+	Load       LoadSpec      `yaml:"load"`       // 载荷
+	Thresholds ThresholdSpec `yaml:"thresholds"` // 运行结束后检查的阈值
+	Reports    []ReportSpec  `yaml:"reports"`    // 报告的输出
+
+	file      string              // 计划文件的路径
+	positions map[string]position // 各配置项在文件中的位置
+}
+
+// 调用器的配置
+type CallerSpec struct {
+	Type   string `yaml:"type"`   // 调用器的类型：tcp 或 http
+	Target string `yaml:"target"` // tcp 为 host:port，http 为 URL 模板
+
+	// 以下仅对 tcp 调用器有效
+	Conns   int    `yaml:"conns"`   // 持久连接数，为 0 时每次请求都新建连接
+	Framing string `yaml:"framing"` // 消息的分帧方式：delim（默认）、length 或 varint
+
+	// 以下仅对 http 调用器有效
+	Method              string            `yaml:"method"`
+	Headers             map[string]string `yaml:"headers"`
+	Body                string            `yaml:"body"`
+	MaxIdleConnsPerHost int               `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int               `yaml:"max_conns_per_host"`
+	DisableKeepAlives   bool              `yaml:"disable_keep_alives"`
+	InsecureSkipVerify  bool              `yaml:"insecure_skip_verify"`
+	CAFile              string            `yaml:"ca_file"`
+	Assert              AssertSpec        `yaml:"assert"`
+}
+
+// 对 http 响应的断言
+type AssertSpec struct {
+	StatusCodes []int             `yaml:"status_codes"`
+	Headers     map[string]string `yaml:"headers"`
+	BodyRegexp  string            `yaml:"body_regexp"`
+	JSONPaths   map[string]string `yaml:"json_paths"`
+}
+
+// 载荷的配置，时长均使用 time.ParseDuration 的格式，例如 1m30s
+type LoadSpec struct {
+	LPS         uint32       `yaml:"lps"`           // 恒定的载荷量，指定了载荷曲线时可省略
+	Profile     *ProfileSpec `yaml:"profile"`       // 载荷曲线
+	Arrival     *ArrivalSpec `yaml:"arrival"`       // 到达过程
+	Timeout     string       `yaml:"timeout"`       // 调用的超时时间
+	Duration    string       `yaml:"duration"`      // 持续时长
+	Drain       string       `yaml:"drain"`         // 停止时等待正在进行的调用的期限
+	MaxInFlight uint32       `yaml:"max_in_flight"` // 允许同时进行的调用数
+	Abort       *AbortSpec   `yaml:"abort"`         // 中止策略
+	RecordLate  bool         `yaml:"record_late"`   // 是否记录超时之后才返回的响应
+}
+
+// 到达过程的配置
+type ArrivalSpec struct {
+	Type   string  `yaml:"type"`   // constant、poisson、uniform 或 trace
+	Seed   int64   `yaml:"seed"`   // 随机数种子，用于 poisson 和 uniform
+	Jitter float64 `yaml:"jitter"` // 抖动的幅度，用于 uniform
+	Trace  string  `yaml:"trace"`  // 记录的间隔文件，用于 trace，格式见 lib.ReadTrace
+}
+
+// 中止策略的配置，含义见 lib.AbortPolicy
+type AbortSpec struct {
+	MaxConsecutiveFailures uint32  `yaml:"max_consecutive_failures"`
+	MaxErrorRatio          float64 `yaml:"max_error_ratio"`
+	MaxTimeoutRatio        float64 `yaml:"max_timeout_ratio"`
+	Window                 uint32  `yaml:"window"`
+}
+
+// 载荷曲线的配置，各类型使用的字段见 lib 中对应的构造函数
+type ProfileSpec struct {
+	Type      string `yaml:"type"` // constant、ramp、step、spike 或 sine
+	LPS       uint32 `yaml:"lps"`
+	From      uint32 `yaml:"from"`
+	To        uint32 `yaml:"to"`
+	Ramp      string `yaml:"ramp"`
+	Start     uint32 `yaml:"start"`
+	Step      uint32 `yaml:"step"`
+	Hold      string `yaml:"hold"`
+	Steps     uint32 `yaml:"steps"`
+	Base      uint32 `yaml:"base"`
+	Peak      uint32 `yaml:"peak"`
+	At        string `yaml:"at"`
+	Length    string `yaml:"length"`
+	Amplitude uint32 `yaml:"amplitude"`
+	Period    string `yaml:"period"`
+}
+
+// 阈值的配置
+type ThresholdSpec struct {
+	MaxP99       string   `yaml:"max_p99"`        // 响应时间的 99 分位的上限
+	MaxErrorRate *float64 `yaml:"max_error_rate"` // 未成功的结果所占比例的上限
+	Rules        []string `yaml:"rules"`          // 阈值表达式，格式见 threshold.Parse
+}
+
+// 报告的配置
+type ReportSpec struct {
+	Format string `yaml:"format"` // text 或 json
+	Path   string `yaml:"path"`   // 输出的文件，为 - 时输出到标准输出
+}
+
+// 从文件加载测试计划，YAML 和 JSON 格式均可
+func Load(file string) (*Plan, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(file, data)
+}
+
+// 解析测试计划，参数 file 仅用于错误信息
+// 返回的错误是 ErrorList 类型，包含了所有未通过检查的配置项的位置
+func Parse(file string, data []byte) (*Plan, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, ErrorList{newDecodeError(file, err.Error())}
+	}
+	plan := &Plan{
+		file:      file,
+		positions: make(map[string]position),
+	}
+	collectPositions(&root, "", plan.positions)
+
+	// 严格解码，未知的配置项和类型错误都会被报告
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(plan); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, ErrorList{newDecodeError(file, err.Error())}
+		}
+		var errs ErrorList
+		for _, msg := range typeErr.Errors {
+			errs = append(errs, newDecodeError(file, msg))
+		}
+		return nil, errs
+	}
+	// 只检查配置项，调用器等到生成参数时才新建
+	v := newValidator(plan)
+	plan.check(v, make(chan *lib.CallResult))
+	if errs := v.errors(); errs != nil {
+		return nil, errs
+	}
+	return plan, nil
+}
+
+// 根据测试计划生成载荷发生器的参数，每次调用都会新建调用器
+// resultCh 为 nil 时需要在新建载荷发生器之前设置 ResultCh 或 Sink
+func (plan *Plan) ParamSet(resultCh chan *lib.CallResult) (lpstest.ParamSet, error) {
+	v := newValidator(plan)
+	pset := plan.build(v, resultCh)
+	if errs := v.errors(); errs != nil {
+		return pset, errs
+	}
+	return pset, nil
+}
+
+// 测试计划中的所有阈值，max_p99 和 max_error_rate 会被转换为等价的阈值
+// 返回的错误包含了所有无法解析的阈值
+func (plan *Plan) ThresholdSet() (threshold.Set, error) {
+	var exprs []string
+	if d, _ := time.ParseDuration(plan.Thresholds.MaxP99); d > 0 {
+		exprs = append(exprs, fmt.Sprintf("p99 ResponseTime <= %v", d))
+	}
+	if rate := plan.Thresholds.MaxErrorRate; rate != nil {
+		// 不能使用 %g，它会把很小的比例格式化为指数形式
+		exprs = append(exprs, "error rate <= "+strconv.FormatFloat(*rate, 'f', -1, 64))
+	}
+	exprs = append(exprs, plan.Thresholds.Rules...)
+	return threshold.ParseSet(exprs...)
+}
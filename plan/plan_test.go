@@ -0,0 +1,240 @@
+package plan
+
+import (
+	"errors"
+	"lpstest/lib"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const yamlPlan = `caller:
+  type: http
+  target: http://127.0.0.1:8080/echo?id={{.ID}}
+  method: POST
+  headers:
+    Content-Type: application/json
+  body: '{"id":{{.ID}}}'
+  assert:
+    status_codes: [200]
+load:
+  profile:
+    type: ramp
+    from: 10
+    to: 100
+    ramp: 30s
+  arrival:
+    type: poisson
+    seed: 42
+  timeout: 500ms
+  duration: 1m
+  drain: 5s
+  abort:
+    max_consecutive_failures: 100
+    max_error_ratio: 0.5
+  record_late: true
+thresholds:
+  max_p99: 200ms
+  max_error_rate: 0.01
+  rules:
+    - achieved LPS >= 95% of target
+reports:
+  - format: json
+    path: report.json
+`
+
+const jsonPlan = `{
+  "caller": {"type": "tcp", "target": "127.0.0.1:8080"},
+  "load": {"lps": 100, "timeout": "50ms", "duration": "10s"}
+}`
+
+func TestParse(t *testing.T) {
+	p, err := Parse("plan.yaml", []byte(yamlPlan))
+	if err != nil {
+		t.Fatalf("Parsing the YAML plan failing: %s", err)
+	}
+	pset, err := p.ParamSet(make(chan *lib.CallResult))
+	if err != nil {
+		t.Fatalf("Building the parameters failing: %s", err)
+	}
+	if err := pset.Check(); err != nil {
+		t.Fatalf("Invalid parameters: %s", err)
+	}
+	if pset.Profile == nil || pset.Profile.MaxLPS() != 100 {
+		t.Fatalf("Inconsistent load profile: %#v", pset.Profile)
+	}
+	if pset.Arrival == nil {
+		t.Fatal("Missing arrival process!")
+	}
+	if pset.TimeoutNS != 500*time.Millisecond || pset.DurationNS != time.Minute || pset.DrainNS != 5*time.Second {
+		t.Fatalf("Inconsistent durations: timeout=%v, duration=%v, drain=%v", pset.TimeoutNS, pset.DurationNS, pset.DrainNS)
+	}
+	if pset.AbortPolicy == nil || pset.AbortPolicy.MaxConsecutiveFailures != 100 || pset.AbortPolicy.MaxErrorRatio != 0.5 {
+		t.Fatalf("Inconsistent abort policy: %#v", pset.AbortPolicy)
+	}
+	if !pset.RecordLate {
+		t.Fatal("Recording late responses should be enabled!")
+	}
+	if set, err := p.ThresholdSet(); err != nil || len(set) != 3 {
+		t.Fatalf("Inconsistent thresholds: %#v (%v)", p.Thresholds, err)
+	}
+	if len(p.Reports) != 1 || p.Reports[0].Format != "json" {
+		t.Fatalf("Inconsistent reports: %#v", p.Reports)
+	}
+
+	p, err = Parse("plan.json", []byte(jsonPlan))
+	if err != nil {
+		t.Fatalf("Parsing the JSON plan failing: %s", err)
+	}
+	pset, err = p.ParamSet(make(chan *lib.CallResult))
+	if err != nil {
+		t.Fatalf("Building the parameters failing: %s", err)
+	}
+	if pset.LPS != 100 || pset.TimeoutNS != 50*time.Millisecond || pset.DurationNS != 10*time.Second {
+		t.Fatalf("Inconsistent parameters: lps=%d, timeout=%v, duration=%v", pset.LPS, pset.TimeoutNS, pset.DurationNS)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	data := `caller:
+  type: udp
+load:
+  profile:
+    type: step
+    start: 10
+    hold: 10x
+  arrival:
+    type: trace
+  timeout: 0s
+thresholds:
+  max_error_rate: 2
+  rules:
+    - p95 latency < 1s
+reports:
+  - format: xml
+    path: report.xml
+`
+	_, err := Parse("plan.yaml", []byte(data))
+	var errs ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	t.Logf("Expected errors:\n%s", errs)
+	expected := []struct {
+		path string
+		line int
+	}{
+		{"caller.type", 2},
+		{"load.profile.hold", 7},
+		{"load.arrival.trace", 8},
+		{"load.timeout", 10},
+		{"load.duration", 3},
+		{"thresholds.max_error_rate", 12},
+		{"thresholds.rules[0]", 14},
+		{"reports[0].format", 16},
+	}
+	for _, exp := range expected {
+		found := false
+		for _, e := range errs {
+			if e.Path == exp.path {
+				found = true
+				if e.Line != exp.line {
+					t.Errorf("Inconsistent line of %s: expected: %d, actual: %d", exp.path, exp.line, e.Line)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("Missing error of %s!", exp.path)
+		}
+	}
+	if len(errs) != len(expected) {
+		t.Errorf("Inconsistent error count: expected: %d, actual: %d", len(expected), len(errs))
+	}
+}
+
+func TestParseUnknownField(t *testing.T) {
+	data := `caller:
+  type: tcp
+  target: 127.0.0.1:8080
+load:
+  lps: many
+  timeuot: 1s
+`
+	_, err := Parse("plan.yaml", []byte(data))
+	var errs ErrorList
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if errs[0].Line != 5 || errs[1].Line != 6 {
+		t.Fatalf("Inconsistent lines: %s", errs)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "plan.json")
+	if err := os.WriteFile(file, []byte(jsonPlan), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(file); err != nil {
+		t.Fatalf("Loading the plan failing: %s", err)
+	}
+	if _, err := Load(file + ".missing"); err == nil {
+		t.Fatal("Loading a missing file should fail!")
+	}
+}
+
+func TestParseWithoutBuilding(t *testing.T) {
+	data := `caller:
+  type: http
+  target: http://127.0.0.1:8080/
+  ca_file: missing.pem
+load:
+  lps: 100
+  timeout: 50ms
+  duration: 10s
+  arrival:
+    type: trace
+    trace: missing.trace
+`
+	// 解析时只检查配置项，不会读取文件
+	p, err := Parse("plan.yaml", []byte(data))
+	if err != nil {
+		t.Fatalf("Parsing the plan failing: %s", err)
+	}
+	_, err = p.ParamSet(make(chan *lib.CallResult))
+	var errs ErrorList
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Path != "load.arrival.trace" || errs[0].Line != 11 {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	p.Load.Arrival = nil
+	_, err = p.ParamSet(make(chan *lib.CallResult))
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Path != "caller" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// 执行时才会出错的模板在解析时就应被发现
+	data = strings.Replace(data, "http://127.0.0.1:8080/", "http://127.0.0.1:8080/{{.Foo}}", 1)
+	if _, err := Parse("plan.yaml", []byte(data)); !errors.As(err, &errs) || errs[0].Path != "caller" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestThresholdSet(t *testing.T) {
+	rate := 0.00001
+	p := &Plan{Thresholds: ThresholdSpec{MaxP99: "1m30s", MaxErrorRate: &rate}}
+	set, err := p.ThresholdSet()
+	if err != nil || len(set) != 2 {
+		t.Fatalf("Inconsistent thresholds: %v (%v)", set, err)
+	}
+	if set[1].Expr != "error rate <= 0.00001" {
+		t.Fatalf("Inconsistent error rate threshold: %s", set[1].Expr)
+	}
+
+	// 无法解析的阈值不会被忽略
+	p.Thresholds.Rules = []string{"count > 0", "p95 latency < 1s"}
+	if _, err := p.ThresholdSet(); err == nil {
+		t.Fatal("Invalid rules should be reported!")
+	}
+}
@@ -0,0 +1,446 @@
+package plan
+
+import (
+	"fmt"
+	"lpstest"
+	"lpstest/httpcaller"
+	"lpstest/lib"
+	helper "lpstest/testhelper"
+	"lpstest/threshold"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 配置项在文件中的位置
+type position struct {
+	line   int
+	column int
+}
+
+// 代表测试计划中一个有问题的配置项
+type Error struct {
+	File   string // 计划文件的路径
+	Line   int    // 行号，从 1 开始，为 0 时表示未知
+	Column int    // 列号，从 1 开始，为 0 时表示未知
+	Path   string // 配置项的路径，例如 load.profile.type
+	Msg    string // 错误信息
+}
+
+func (e Error) Error() string {
+	var buf strings.Builder
+	if e.File != "" {
+		buf.WriteString(e.File)
+		buf.WriteString(":")
+	}
+	if e.Line > 0 {
+		fmt.Fprintf(&buf, "%d:", e.Line)
+		if e.Column > 0 {
+			fmt.Fprintf(&buf, "%d:", e.Column)
+		}
+	}
+	if buf.Len() > 0 {
+		buf.WriteString(" ")
+	}
+	if e.Path != "" {
+		buf.WriteString(e.Path)
+		buf.WriteString(": ")
+	}
+	buf.WriteString(e.Msg)
+	return buf.String()
+}
+
+// 测试计划中所有有问题的配置项
+type ErrorList []Error
+
+func (errs ErrorList) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// 匹配 yaml 错误信息中的行号
+var lineRegexp = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// 把 yaml 的解析错误转换为 Error
+func newDecodeError(file, msg string) Error {
+	e := Error{File: file, Msg: msg}
+	if m := lineRegexp.FindStringSubmatch(msg); m != nil {
+		e.Line, _ = strconv.Atoi(m[1])
+		e.Msg = m[2]
+	}
+	return e
+}
+
+// 记录各配置项在文件中的位置，键为配置项的路径
+func collectPositions(node *yaml.Node, path string, positions map[string]position) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			collectPositions(child, path, positions)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			childPath := key.Value
+			if path != "" {
+				childPath = path + "." + key.Value
+			}
+			positions[childPath] = position{key.Line, key.Column}
+			collectPositions(value, childPath, positions)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			childPath := fmt.Sprintf("%s[%d]", path, i)
+			positions[childPath] = position{item.Line, item.Column}
+			collectPositions(item, childPath, positions)
+		}
+	}
+}
+
+// ParamSet 中的字段对应的配置项，调用器的错误由 checkCaller 报告
+var paramPaths = map[string]string{
+	"TimeoutNS":   "load.timeout",
+	"LPS":         "load.lps",
+	"Profile":     "load.profile",
+	"DurationNS":  "load.duration",
+	"DrainNS":     "load.drain",
+	"AbortPolicy": "load.abort",
+}
+
+// 测试计划的检查器，收集所有有问题的配置项
+type validator struct {
+	plan *Plan
+	errs ErrorList
+}
+
+func newValidator(plan *Plan) *validator {
+	return &validator{plan: plan}
+}
+
+// 记录一个有问题的配置项
+func (v *validator) errorf(path, format string, args ...any) {
+	pos := v.position(path)
+	v.errs = append(v.errs, Error{
+		File:   v.plan.file,
+		Line:   pos.line,
+		Column: pos.column,
+		Path:   path,
+		Msg:    fmt.Sprintf(format, args...),
+	})
+}
+
+// 配置项的位置，配置项不存在时使用最近的上级配置项的位置
+func (v *validator) position(path string) position {
+	for path != "" {
+		if pos, ok := v.plan.positions[path]; ok {
+			return pos
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return position{}
+}
+
+// 判断配置项本身或其下级配置项是否已经有错误
+func (v *validator) reported(path string) bool {
+	for _, e := range v.errs {
+		if e.Path == path || strings.HasPrefix(e.Path, path+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// 按位置排序后返回所有错误，没有错误时返回 nil
+func (v *validator) errors() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		return v.errs[i].Line < v.errs[j].Line
+	})
+	return v.errs
+}
+
+// 解析时长，为空时返回 0
+func (v *validator) duration(path, value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		v.errorf(path, "Invalid duration %q!", value)
+		return 0
+	}
+	if d < 0 {
+		v.errorf(path, "Invalid duration %q! (negative)", value)
+		return 0
+	}
+	return d
+}
+
+// 根据测试计划生成载荷发生器的参数，所有配置项都通过检查之后才会新建调用器和到达过程
+func (plan *Plan) build(v *validator, resultCh chan *lib.CallResult) lpstest.ParamSet {
+	pset := plan.check(v, resultCh)
+	if len(v.errs) > 0 {
+		return pset
+	}
+	if pset.Arrival = plan.newArrival(v); len(v.errs) > 0 {
+		return pset
+	}
+	pset.Caller = plan.newCaller(v)
+	return pset
+}
+
+// 检查所有配置项，并生成除调用器和到达过程以外的参数
+// 它只检查配置项本身，不会新建连接池，也不会读取文件
+func (plan *Plan) check(v *validator, resultCh chan *lib.CallResult) lpstest.ParamSet {
+	plan.checkCaller(v)
+	plan.checkArrival(v)
+	pset := lpstest.ParamSet{
+		TimeoutNS:   v.duration("load.timeout", plan.Load.Timeout),
+		LPS:         plan.Load.LPS,
+		Profile:     plan.newProfile(v),
+		DurationNS:  v.duration("load.duration", plan.Load.Duration),
+		DrainNS:     v.duration("load.drain", plan.Load.Drain),
+		MaxInFlight: plan.Load.MaxInFlight,
+		ResultCh:    resultCh,
+		RecordLate:  plan.Load.RecordLate,
+	}
+	if spec := plan.Load.Abort; spec != nil {
+		pset.AbortPolicy = &lib.AbortPolicy{
+			MaxConsecutiveFailures: spec.MaxConsecutiveFailures,
+			MaxErrorRatio:          spec.MaxErrorRatio,
+			MaxTimeoutRatio:        spec.MaxTimeoutRatio,
+			Window:                 spec.Window,
+		}
+	}
+	if plan.Load.Profile != nil && plan.Load.LPS > 0 {
+		v.errorf("load.lps", "The lps and the profile can not be specified together!")
+	}
+	for _, e := range pset.Errors() {
+		path, ok := paramPaths[e.Field]
+		if !ok || v.reported(path) {
+			continue
+		}
+		// 载荷曲线有误时不再报告载荷量
+		if e.Field == "LPS" && plan.Load.Profile != nil {
+			continue
+		}
+		v.errorf(path, "%s", e.Msg)
+	}
+	plan.checkThresholds(v)
+	plan.checkReports(v)
+	return pset
+}
+
+// 检查调用器的配置
+func (plan *Plan) checkCaller(v *validator) {
+	spec := plan.Caller
+	switch spec.Type {
+	case "tcp", "http":
+	case "":
+		v.errorf("caller.type", "Missing caller type! (expected: tcp or http)")
+		return
+	default:
+		v.errorf("caller.type", "Unknown caller type %q! (expected: tcp or http)", spec.Type)
+		return
+	}
+	if spec.Target == "" {
+		v.errorf("caller.target", "Missing target!")
+		return
+	}
+	if spec.Type == "tcp" {
+		if spec.Conns < 0 {
+			v.errorf("caller.conns", "Invalid conns %d!", spec.Conns)
+		}
+		if _, err := helper.NewCodec(spec.Framing, 0); err != nil {
+			v.errorf("caller.framing", "%s", err)
+		}
+		return
+	}
+	if err := plan.httpConfig().Check(); err != nil {
+		v.errorf("caller", "%s", err)
+	}
+}
+
+// 根据配置新建调用器，须在配置通过检查之后调用
+func (plan *Plan) newCaller(v *validator) lib.Caller {
+	spec := plan.Caller
+	if spec.Type == "tcp" {
+		codec, err := helper.NewCodec(spec.Framing, 0)
+		if err != nil {
+			v.errorf("caller.framing", "%s", err)
+			return nil
+		}
+		if spec.Conns == 0 {
+			return helper.NewTCPCommWithCodec(spec.Target, codec)
+		}
+		pool, err := helper.NewTCPPoolWithCodec(spec.Target, spec.Conns, codec)
+		if err != nil {
+			v.errorf("caller.conns", "%s", err)
+			return nil
+		}
+		return pool
+	}
+	caller, err := httpcaller.NewHTTPCaller(plan.httpConfig())
+	if err != nil {
+		v.errorf("caller", "%s", err)
+		return nil
+	}
+	return caller
+}
+
+// 根据配置生成 HTTP 调用器的配置
+func (plan *Plan) httpConfig() httpcaller.Config {
+	spec := plan.Caller
+	return httpcaller.Config{
+		Method:              spec.Method,
+		URL:                 spec.Target,
+		Headers:             spec.Headers,
+		Body:                spec.Body,
+		MaxIdleConnsPerHost: spec.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     spec.MaxConnsPerHost,
+		DisableKeepAlives:   spec.DisableKeepAlives,
+		InsecureSkipVerify:  spec.InsecureSkipVerify,
+		CAFile:              spec.CAFile,
+		Assert: httpcaller.Assertion{
+			StatusCodes: spec.Assert.StatusCodes,
+			Headers:     spec.Assert.Headers,
+			BodyRegexp:  spec.Assert.BodyRegexp,
+			JSONPaths:   spec.Assert.JSONPaths,
+		},
+	}
+}
+
+// 根据配置新建载荷曲线，未配置时返回 nil
+func (plan *Plan) newProfile(v *validator) lib.LoadProfile {
+	spec := plan.Load.Profile
+	if spec == nil {
+		return nil
+	}
+	var profile lib.LoadProfile
+	var err error
+	switch spec.Type {
+	case "constant":
+		profile, err = lib.NewConstantProfile(spec.LPS)
+	case "ramp":
+		profile, err = lib.NewRampProfile(spec.From, spec.To,
+			v.duration("load.profile.ramp", spec.Ramp))
+	case "step":
+		profile, err = lib.NewStepProfile(spec.Start, spec.Step,
+			v.duration("load.profile.hold", spec.Hold), spec.Steps)
+	case "spike":
+		profile, err = lib.NewSpikeProfile(spec.Base, spec.Peak,
+			v.duration("load.profile.at", spec.At), v.duration("load.profile.length", spec.Length))
+	case "sine":
+		profile, err = lib.NewSineProfile(spec.Base, spec.Amplitude,
+			v.duration("load.profile.period", spec.Period))
+	default:
+		v.errorf("load.profile.type", "Unknown profile type %q! (expected: constant, ramp, step, spike or sine)", spec.Type)
+		return nil
+	}
+	if err != nil {
+		if !v.reported("load.profile") {
+			v.errorf("load.profile", "%s", err)
+		}
+		return nil
+	}
+	return profile
+}
+
+// 检查到达过程的配置，不会读取记录的间隔文件
+func (plan *Plan) checkArrival(v *validator) {
+	spec := plan.Load.Arrival
+	if spec == nil {
+		return
+	}
+	switch spec.Type {
+	case "constant", "poisson":
+	case "uniform":
+		if _, err := lib.NewUniformArrival(spec.Jitter, spec.Seed); err != nil {
+			v.errorf("load.arrival.jitter", "%s", err)
+		}
+	case "trace":
+		if spec.Trace == "" {
+			v.errorf("load.arrival.trace", "Missing trace file!")
+		}
+	default:
+		v.errorf("load.arrival.type", "Unknown arrival type %q! (expected: constant, poisson, uniform or trace)", spec.Type)
+	}
+}
+
+// 根据配置新建到达过程，未配置时返回 nil，须在配置通过检查之后调用
+func (plan *Plan) newArrival(v *validator) lib.ArrivalProcess {
+	spec := plan.Load.Arrival
+	if spec == nil {
+		return nil
+	}
+	switch spec.Type {
+	case "poisson":
+		return lib.NewPoissonArrival(spec.Seed)
+	case "uniform":
+		arrival, err := lib.NewUniformArrival(spec.Jitter, spec.Seed)
+		if err != nil {
+			v.errorf("load.arrival.jitter", "%s", err)
+		}
+		return arrival
+	case "trace":
+		file, err := os.Open(spec.Trace)
+		if err != nil {
+			v.errorf("load.arrival.trace", "%s", err)
+			return nil
+		}
+		defer file.Close()
+		intervals, err := lib.ReadTrace(file)
+		if err == nil {
+			var arrival lib.ArrivalProcess
+			if arrival, err = lib.NewTraceArrival(intervals); err == nil {
+				return arrival
+			}
+		}
+		v.errorf("load.arrival.trace", "%s: %s", spec.Trace, err)
+		return nil
+	default:
+		return lib.NewConstantArrival()
+	}
+}
+
+// 检查阈值的配置
+func (plan *Plan) checkThresholds(v *validator) {
+	v.duration("thresholds.max_p99", plan.Thresholds.MaxP99)
+	if rate := plan.Thresholds.MaxErrorRate; rate != nil && (*rate < 0 || *rate > 1) {
+		v.errorf("thresholds.max_error_rate", "Invalid error rate %v! (expected: 0~1)", *rate)
+	}
+	for i, rule := range plan.Thresholds.Rules {
+		if _, err := threshold.Parse(rule); err != nil {
+			v.errorf(fmt.Sprintf("thresholds.rules[%d]", i), "%s", err)
+		}
+	}
+}
+
+// 检查报告的配置
+func (plan *Plan) checkReports(v *validator) {
+	for i, report := range plan.Reports {
+		switch report.Format {
+		case "text", "json":
+		default:
+			v.errorf(fmt.Sprintf("reports[%d].format", i),
+				"Unknown report format %q! (expected: text or json)", report.Format)
+		}
+		if report.Path == "" {
+			v.errorf(fmt.Sprintf("reports[%d].path", i), "Missing report path! (use - for the standard output)")
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"lpstest"
+	"lpstest/lib"
+	"lpstest/stats"
+	"lpstest/threshold"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 声明代表搜索策略的常量
+const (
+	STRATEGY_BINARY  = iota // 二分查找
+	STRATEGY_STEP_UP        // 逐级递增，直到不可持续
+)
+
+// 未指定阈值时使用的默认阈值
+var DefaultThresholds = threshold.MustParseSet(
+	"achieved LPS >= 95% of target",
+	"error rate < 1%",
+)
+
+// 搜索的配置
+type Config struct {
+	// 每一轮载荷发生器的参数，其中 LPS、Profile、DurationNS、ResultCh 和 Sink 会被替换
+	ParamSet lpstest.ParamSet
+	// 判断一个载荷量是否可持续的阈值，为空时使用 DefaultThresholds
+	Thresholds threshold.Set
+	Strategy   int
+	MinLPS     uint32        // 搜索的下限
+	MaxLPS     uint32        // 搜索的上限
+	Step       uint32        // 逐级递增时每一轮增加的载荷量
+	Precision  uint32        // 二分查找时，上下限之差不大于它即停止，默认为 1
+	LevelNS    time.Duration // 每一轮的持续时长
+	CooldownNS time.Duration // 两轮之间的间隔，让承受方恢复
+	// 每一轮结束后调用，可以为 nil
+	OnPoint func(Point)
+}
+
+// 检查配置
+func (cfg *Config) Check() error {
+	var errMsgs []string
+	if cfg.MinLPS == 0 || cfg.MaxLPS < cfg.MinLPS {
+		errMsgs = append(errMsgs, fmt.Sprintf("Invalid lps range! (min=%d, max=%d)", cfg.MinLPS, cfg.MaxLPS))
+	}
+	switch cfg.Strategy {
+	case STRATEGY_BINARY:
+	case STRATEGY_STEP_UP:
+		if cfg.Step == 0 {
+			errMsgs = append(errMsgs, "Invalid step!")
+		}
+	default:
+		errMsgs = append(errMsgs, fmt.Sprintf("Invalid strategy %d!", cfg.Strategy))
+	}
+	if cfg.LevelNS <= 0 {
+		errMsgs = append(errMsgs, "Invalid levelNS!")
+	}
+	if cfg.CooldownNS < 0 {
+		errMsgs = append(errMsgs, "Invalid cooldownNS!")
+	}
+	if errMsgs != nil {
+		return errors.New(strings.Join(errMsgs, " "))
+	}
+	return nil
+}
+
+// 在一个载荷量上测得的结果
+type Point struct {
+	LPS         uint32            // 目标载荷量
+	Summary     stats.Summary     // 结果的统计摘要
+	Verdict     threshold.Verdict // 阈值的评估结果
+	Stop        lib.StopReason    // 载荷发生器停止的原因
+	Sustainable bool              // 是否可持续，即通过了所有阈值且未被中止
+}
+
+// 搜索的结果
+type Result struct {
+	MaxLPS uint32  // 可持续的最大载荷量，为 0 时表示下限也不可持续
+	Points []Point // 按测量顺序排列的各轮结果
+}
+
+// 按载荷量排序的吞吐量与耗时曲线
+func (r Result) Curve() []Point {
+	curve := make([]Point, len(r.Points))
+	copy(curve, r.Points)
+	sort.SliceStable(curve, func(i, j int) bool {
+		return curve[i].LPS < curve[j].LPS
+	})
+	return curve
+}
+
+func (r Result) String() string {
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("%10s %12s %12s %12s %12s %8s  %s\n",
+		"lps", "throughput", "p50", "p99", "p99.9", "errors", "sustainable"))
+	for _, p := range r.Curve() {
+		errorRate := 0.0
+		if p.Summary.Count > 0 {
+			errorRate = 1 - p.Summary.Ratio(lib.RET_CODE_SUCCESS)
+		}
+		buf.WriteString(fmt.Sprintf("%10d %12.2f %12v %12v %12v %7.2f%%  %v\n",
+			p.LPS, p.Summary.Throughput, p.Summary.Response.P50, p.Summary.Response.P99,
+			p.Summary.Response.P999, errorRate*100, p.Sustainable))
+	}
+	buf.WriteString(fmt.Sprintf("max sustainable lps: %d\n", r.MaxLPS))
+	return buf.String()
+}
+
+// 搜索可持续的最大载荷量，上下文被取消时停止当前一轮并返回已测得的结果
+func Run(ctx context.Context, cfg Config) (Result, error) {
+	if err := cfg.Check(); err != nil {
+		return Result{}, err
+	}
+	if len(cfg.Thresholds) == 0 {
+		cfg.Thresholds = DefaultThresholds
+	}
+	s := &searcher{ctx: ctx, cfg: cfg}
+	var err error
+	if cfg.Strategy == STRATEGY_STEP_UP {
+		err = s.stepUp()
+	} else {
+		err = s.binary()
+	}
+	return s.result, err
+}
+
+// 执行一次搜索
+type searcher struct {
+	ctx    context.Context
+	cfg    Config
+	result Result
+}
+
+// 逐级递增，直到不可持续或达到上限
+func (s *searcher) stepUp() error {
+	for lps := uint64(s.cfg.MinLPS); lps <= uint64(s.cfg.MaxLPS); lps += uint64(s.cfg.Step) {
+		ok, err := s.measure(uint32(lps))
+		if err != nil || !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// 二分查找，先测量上下限，再在可持续与不可持续的载荷量之间查找
+func (s *searcher) binary() error {
+	precision := s.cfg.Precision
+	if precision == 0 {
+		precision = 1
+	}
+	ok, err := s.measure(s.cfg.MinLPS)
+	if err != nil || !ok || s.cfg.MaxLPS == s.cfg.MinLPS {
+		return err
+	}
+	ok, err = s.measure(s.cfg.MaxLPS)
+	if err != nil || ok {
+		return err
+	}
+	low, high := s.cfg.MinLPS, s.cfg.MaxLPS
+	for high-low > precision {
+		mid := low + (high-low)/2
+		ok, err := s.measure(mid)
+		if err != nil {
+			return err
+		}
+		if ok {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return nil
+}
+
+// 在给定的载荷量上运行一轮，返回它是否可持续
+func (s *searcher) measure(lps uint32) (bool, error) {
+	if len(s.result.Points) > 0 && s.cfg.CooldownNS > 0 {
+		select {
+		case <-time.After(s.cfg.CooldownNS):
+		case <-s.ctx.Done():
+			return false, s.ctx.Err()
+		}
+	}
+	if err := s.ctx.Err(); err != nil {
+		return false, err
+	}
+	pset := s.cfg.ParamSet
+	pset.LPS = lps
+	pset.Profile = nil
+	pset.DurationNS = s.cfg.LevelNS
+	collector := stats.NewCollector()
+	done := make(chan struct{})
+	pset.ResultCh = nil
+	pset.Sink = lib.NewFuncSink(collector.Add, func() { close(done) })
+	gen, err := lpstest.NewGenerator(pset)
+	if err != nil {
+		return false, err
+	}
+	gen.Start()
+	select {
+	case <-done:
+	case <-s.ctx.Done():
+		gen.Stop()
+		<-done
+		return false, s.ctx.Err()
+	}
+
+	point := Point{
+		LPS:     lps,
+		Summary: collector.Snapshot(s.cfg.LevelNS),
+		Verdict: s.cfg.Thresholds.Evaluate(threshold.NewInput(collector, s.cfg.LevelNS, float64(lps))),
+		Stop:    gen.StopReason(),
+	}
+	point.Sustainable = point.Verdict.Passed && point.Stop.Kind != lib.STOP_REASON_ABORTED
+	s.result.Points = append(s.result.Points, point)
+	if point.Sustainable && lps > s.result.MaxLPS {
+		s.result.MaxLPS = lps
+	}
+	if s.cfg.OnPoint != nil {
+		s.cfg.OnPoint(point)
+	}
+	return point.Sustainable, nil
+}
@@ -0,0 +1,106 @@
+package search
+
+import (
+	"context"
+	"lpstest"
+	"lpstest/lib"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 串行处理调用的调用器，每次调用耗时固定，因此容量约为 1s / serviceNS
+type serialCaller struct {
+	serviceNS time.Duration
+	lock      sync.Mutex
+	id        int64
+}
+
+func (caller *serialCaller) BuildRed() lib.RawReq {
+	id := atomic.AddInt64(&caller.id, 1)
+	return lib.RawReq{ID: id, Req: []byte(strconv.FormatInt(id, 10))}
+}
+
+func (caller *serialCaller) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	caller.lock.Lock()
+	defer caller.lock.Unlock()
+	time.Sleep(caller.serviceNS)
+	return req, nil
+}
+
+func (caller *serialCaller) CheckResp(rawReq lib.RawReq, rawResp lib.RawResp) *lib.CallResult {
+	return &lib.CallResult{ID: rawResp.ID, Req: rawReq, Resp: rawResp, Code: lib.RET_CODE_SUCCESS}
+}
+
+func newConfig(strategy int) Config {
+	return Config{
+		ParamSet: lpstest.ParamSet{
+			Caller:    &serialCaller{serviceNS: 5 * time.Millisecond},
+			TimeoutNS: 50 * time.Millisecond,
+			DrainNS:   time.Second,
+		},
+		Strategy:  strategy,
+		MinLPS:    50,
+		MaxLPS:    800,
+		Step:      100,
+		Precision: 50,
+		LevelNS:   500 * time.Millisecond,
+	}
+}
+
+func TestSearch(t *testing.T) {
+	cases := []struct {
+		name     string
+		strategy int
+	}{
+		{"binary", STRATEGY_BINARY},
+		{"step-up", STRATEGY_STEP_UP},
+	}
+	for _, c := range cases {
+		cfg := newConfig(c.strategy)
+		var points int
+		cfg.OnPoint = func(p Point) { points++ }
+		result, err := Run(context.Background(), cfg)
+		if err != nil {
+			t.Fatalf("Searching with %s failing: %s", c.name, err)
+		}
+		t.Logf("Result of %s:\n%s", c.name, result)
+		if result.MaxLPS < 50 || result.MaxLPS > 250 {
+			t.Errorf("Inconsistent max lps of %s: expected: 50~250, actual: %d", c.name, result.MaxLPS)
+		}
+		if points != len(result.Points) || len(result.Points) < 2 {
+			t.Errorf("Inconsistent points of %s: %d (callbacks: %d)", c.name, len(result.Points), points)
+		}
+		curve := result.Curve()
+		if last := curve[len(curve)-1]; last.Sustainable {
+			t.Errorf("The highest level of %s should not be sustainable! (lps: %d)", c.name, last.LPS)
+		}
+	}
+}
+
+func TestSearchCanceled(t *testing.T) {
+	cfg := newConfig(STRATEGY_STEP_UP)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(700*time.Millisecond, cancel)
+	start := time.Now()
+	result, err := Run(ctx, cfg)
+	if err != context.Canceled {
+		t.Fatalf("Inconsistent error: expected: %v, actual: %v", context.Canceled, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("The search was not canceled in time! (elapsed: %v)", elapsed)
+	}
+	if len(result.Points) != 1 {
+		t.Fatalf("Inconsistent points: expected: 1, actual: %d", len(result.Points))
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	cfg := newConfig(STRATEGY_STEP_UP)
+	cfg.MinLPS, cfg.MaxLPS, cfg.Step = 100, 10, 0
+	if _, err := Run(context.Background(), cfg); err == nil {
+		t.Fatal("Invalid config should be rejected!")
+	}
+}
@@ -0,0 +1,226 @@
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"lpstest/lib"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 延迟的统计摘要
+type Latency struct {
+	Min  time.Duration // 最小延迟
+	Mean time.Duration // 平均延迟
+	Max  time.Duration // 最大延迟
+	P50  time.Duration // 50 分位延迟
+	P90  time.Duration // 90 分位延迟
+	P99  time.Duration // 99 分位延迟
+	P999 time.Duration // 99.9 分位延迟
+}
+
+// 根据直方图生成延迟的统计摘要
+func NewLatency(h *Histogram) Latency {
+	return Latency{
+		Min:  h.Min(),
+		Mean: h.Mean(),
+		Max:  h.Max(),
+		P50:  h.Percentile(50),
+		P90:  h.Percentile(90),
+		P99:  h.Percentile(99),
+		P999: h.Percentile(99.9),
+	}
+}
+
+func (l Latency) String() string {
+	return fmt.Sprintf("min=%v, mean=%v, max=%v, p50=%v, p90=%v, p99=%v, p99.9=%v",
+		l.Min, l.Mean, l.Max, l.P50, l.P90, l.P99, l.P999)
+}
+
+// 调用结果的统计摘要
+type Summary struct {
+	Count      int64                 // 结果总数
+	Codes      map[lib.RetCode]int64 // 各结果代码的数量
+	Service    Latency               // 服务时间，即 CallResult.Elapse
+	Response   Latency               // 响应时间，即 CallResult.ResponseTime，已校正协同遗漏
+	Duration   time.Duration         // 运行的时长，即获取摘要时给出的时长
+	Throughput float64               // 每秒结果数，按运行的时长计算
+	TPS        float64               // 每秒成功的结果数，按运行的时长计算
+	Late       LateSummary           // 迟到的结果，不计入以上各项
+}
+
+// 迟到的结果（超时或被放弃之后才返回的真实响应）的统计摘要
+type LateSummary struct {
+	Count   int64                 // 迟到的结果数
+	Codes   map[lib.RetCode]int64 // 各结果代码的数量，用于区分慢但正确与出错的响应
+	Service Latency               // 真实的服务时间
+}
+
+// 获取某个结果代码所占的比例
+func (s Summary) Ratio(codes ...lib.RetCode) float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	var n int64
+	for _, code := range codes {
+		n += s.Codes[code]
+	}
+	return float64(n) / float64(s.Count)
+}
+
+func (s Summary) String() string {
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("count=%d, duration=%v, throughput=%.2f/s, tps=%.2f/s\n",
+		s.Count, s.Duration, s.Throughput, s.TPS))
+	buf.WriteString(fmt.Sprintf("service time: %s\n", s.Service))
+	buf.WriteString(fmt.Sprintf("response time: %s\n", s.Response))
+	writeCodes(&buf, s.Codes)
+	if s.Late.Count > 0 {
+		buf.WriteString(fmt.Sprintf("late responses: count=%d, service time: %s\n", s.Late.Count, s.Late.Service))
+		writeCodes(&buf, s.Late.Codes)
+	}
+	return buf.String()
+}
+
+// 按结果代码的顺序输出各结果代码的数量
+func writeCodes(buf *bytes.Buffer, counts map[lib.RetCode]int64) {
+	codes := make([]int, 0, len(counts))
+	for code := range counts {
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		retCode := lib.RetCode(code)
+		buf.WriteString(fmt.Sprintf("  %s (%d): %d\n", lib.GetRetCodePlain(retCode), code, counts[retCode]))
+	}
+}
+
+// 调用结果的收集器，它是并发安全的
+type Collector struct {
+	mutex     sync.Mutex
+	service   *Histogram // 服务时间的直方图
+	response  *Histogram // 响应时间的直方图
+	codes     map[lib.RetCode]int64
+	count     int64
+	late      *Histogram // 迟到的结果的服务时间的直方图
+	lateCodes map[lib.RetCode]int64
+}
+
+// 新建一个调用结果的收集器
+func NewCollector() *Collector {
+	return &Collector{
+		service:   NewHistogram(),
+		response:  NewHistogram(),
+		codes:     make(map[lib.RetCode]int64),
+		late:      NewHistogram(),
+		lateCodes: make(map[lib.RetCode]int64),
+	}
+}
+
+// 添加一个调用结果
+// 没有耗时的结果（例如调用过程中发生了恐慌）只计数，不计入服务时间；
+// 没有响应时间的结果（例如不是由载荷发生器产生的）不计入响应时间；
+// 迟到的结果单独统计，因为同一调用已经以超时的结果计入过一次
+func (c *Collector) Add(result *lib.CallResult) {
+	if result == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if result.Late {
+		c.lateCodes[result.Code]++
+		c.late.Record(result.Elapse)
+		return
+	}
+	c.count++
+	c.codes[result.Code]++
+	if result.Elapse > 0 {
+		c.service.Record(result.Elapse)
+	}
+	if result.ResponseTime > 0 {
+		c.response.Record(result.ResponseTime)
+	}
+}
+
+// 从结果通道中持续收集结果，直到通道被关闭
+func (c *Collector) Consume(resultCh <-chan *lib.CallResult) {
+	for result := range resultCh {
+		c.Add(result)
+	}
+}
+
+// 把另一个收集器的结果合并进来
+func (c *Collector) Merge(other *Collector) {
+	if other == nil || other == c {
+		return
+	}
+	other.mutex.Lock()
+	service := other.service.Copy()
+	response := other.response.Copy()
+	codes := make(map[lib.RetCode]int64, len(other.codes))
+	for code, n := range other.codes {
+		codes[code] = n
+	}
+	count := other.count
+	late := other.late.Copy()
+	lateCodes := make(map[lib.RetCode]int64, len(other.lateCodes))
+	for code, n := range other.lateCodes {
+		lateCodes[code] = n
+	}
+	other.mutex.Unlock()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.late.Merge(late)
+	for code, n := range lateCodes {
+		c.lateCodes[code] += n
+	}
+	if count == 0 {
+		return
+	}
+	c.service.Merge(service)
+	c.response.Merge(response)
+	for code, n := range codes {
+		c.codes[code] += n
+	}
+	c.count += count
+}
+
+// 获取服务时间和响应时间的直方图的副本
+func (c *Collector) Histograms() (service *Histogram, response *Histogram) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.service.Copy(), c.response.Copy()
+}
+
+// 获取当前的统计摘要，可以在运行中随时调用
+// 参数 elapsed 代表产生这些结果的运行时长（墙上时间），用于计算每秒结果数，为 0 时不计算
+func (c *Collector) Snapshot(elapsed time.Duration) Summary {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	summary := Summary{
+		Count:    c.count,
+		Codes:    make(map[lib.RetCode]int64, len(c.codes)),
+		Service:  NewLatency(c.service),
+		Response: NewLatency(c.response),
+		Duration: elapsed,
+	}
+	for code, n := range c.codes {
+		summary.Codes[code] = n
+	}
+	summary.Late = LateSummary{
+		Count:   c.late.Count(),
+		Codes:   make(map[lib.RetCode]int64, len(c.lateCodes)),
+		Service: NewLatency(c.late),
+	}
+	for code, n := range c.lateCodes {
+		summary.Late.Codes[code] = n
+	}
+	if summary.Duration > 0 {
+		seconds := summary.Duration.Seconds()
+		summary.Throughput = float64(c.count) / seconds
+		summary.TPS = float64(c.codes[lib.RET_CODE_SUCCESS]) / seconds
+	}
+	return summary
+}
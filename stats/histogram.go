@@ -0,0 +1,165 @@
+package stats
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// 直方图的精度：每个 2 的幂区间被划分为 subBucketHalf 个等宽的子桶，
+// 因此记录值的相对误差不超过 1/subBucketHalf
+const (
+	subBucketBits  = 8
+	subBucketCount = 1 << subBucketBits
+	subBucketHalf  = subBucketCount / 2
+)
+
+// 延迟直方图，按对数-线性的方式分桶（类似 HDR Histogram）
+// 它不是并发安全的，并发使用时需由调用方加锁
+type Histogram struct {
+	counts []int64 // 各个桶中的记录数
+	total  int64   // 总记录数
+	min    int64   // 最小值
+	max    int64   // 最大值
+	sum    float64 // 所有记录值之和，用于计算平均值
+}
+
+// 新建一个延迟直方图
+func NewHistogram() *Histogram {
+	return &Histogram{}
+}
+
+// 计算记录值所在的桶的索引
+func bucketIndex(v int64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - subBucketBits
+	return shift*subBucketHalf + int(v>>uint(shift))
+}
+
+// 计算桶所代表的取值范围的下界和宽度
+func bucketRange(index int) (low int64, width int64) {
+	if index < subBucketCount {
+		return int64(index), 1
+	}
+	shift := index/subBucketHalf - 1
+	m := int64(index - shift*subBucketHalf)
+	return m << uint(shift), 1 << uint(shift)
+}
+
+// 记录一个延迟
+func (h *Histogram) Record(d time.Duration) {
+	v := int64(d)
+	if v < 0 {
+		v = 0
+	}
+	index := bucketIndex(v)
+	if index >= len(h.counts) {
+		counts := make([]int64, index+1)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[index]++
+	if h.total == 0 || v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	h.total++
+	h.sum += float64(v)
+}
+
+// 总记录数
+func (h *Histogram) Count() int64 {
+	return h.total
+}
+
+// 最小值
+func (h *Histogram) Min() time.Duration {
+	return time.Duration(h.min)
+}
+
+// 最大值
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(h.max)
+}
+
+// 平均值
+func (h *Histogram) Mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / float64(h.total))
+}
+
+// 计算百分位数，参数 p 的取值范围是 [0, 100]
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	if p <= 0 {
+		return time.Duration(h.min)
+	}
+	if p >= 100 {
+		return time.Duration(h.max)
+	}
+	rank := int64(math.Ceil(p / 100 * float64(h.total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for index, count := range h.counts {
+		seen += count
+		if seen < rank {
+			continue
+		}
+		low, width := bucketRange(index)
+		v := low + (width-1)/2
+		if v < h.min {
+			v = h.min
+		}
+		if v > h.max {
+			v = h.max
+		}
+		return time.Duration(v)
+	}
+	return time.Duration(h.max)
+}
+
+// 把另一个直方图的记录合并进来
+func (h *Histogram) Merge(other *Histogram) {
+	if other == nil || other.total == 0 {
+		return
+	}
+	if len(other.counts) > len(h.counts) {
+		counts := make([]int64, len(other.counts))
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	for index, count := range other.counts {
+		h.counts[index] += count
+	}
+	if h.total == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	h.total += other.total
+	h.sum += other.sum
+}
+
+// 复制出一个新的直方图
+func (h *Histogram) Copy() *Histogram {
+	c := *h
+	c.counts = make([]int64, len(h.counts))
+	copy(c.counts, h.counts)
+	return &c
+}
+
+// 清空所有记录
+func (h *Histogram) Reset() {
+	*h = Histogram{}
+}
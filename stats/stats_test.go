@@ -0,0 +1,110 @@
+package stats
+
+import (
+	"lpstest/lib"
+	"math"
+	"testing"
+	"time"
+)
+
+// 判断两个延迟是否在直方图的精度内相等
+func approx(actual, expected time.Duration) bool {
+	return math.Abs(float64(actual-expected))/float64(expected) <= 0.01
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram()
+	for i := 1; i <= 10000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+	if h.Count() != 10000 {
+		t.Fatalf("Inconsistent count: expected: %d, actual: %d", 10000, h.Count())
+	}
+	if h.Min() != time.Microsecond || h.Max() != 10*time.Millisecond {
+		t.Fatalf("Inconsistent min/max: min=%v, max=%v", h.Min(), h.Max())
+	}
+	cases := []struct {
+		p        float64
+		expected time.Duration
+	}{
+		{50, 5 * time.Millisecond},
+		{90, 9 * time.Millisecond},
+		{99, 9900 * time.Microsecond},
+		{99.9, 9990 * time.Microsecond},
+	}
+	for _, c := range cases {
+		actual := h.Percentile(c.p)
+		if !approx(actual, c.expected) {
+			t.Errorf("Inconsistent p%v: expected: %v, actual: %v", c.p, c.expected, actual)
+		}
+	}
+	if mean := h.Mean(); mean < 5000*time.Microsecond || mean > 5001*time.Microsecond {
+		t.Errorf("Inconsistent mean: %v", mean)
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	h1 := NewHistogram()
+	h2 := NewHistogram()
+	for i := 0; i < 100; i++ {
+		h1.Record(time.Millisecond)
+		h2.Record(time.Second)
+	}
+	h1.Merge(h2)
+	if h1.Count() != 200 {
+		t.Fatalf("Inconsistent count: expected: %d, actual: %d", 200, h1.Count())
+	}
+	if p := h1.Percentile(50); !approx(p, time.Millisecond) {
+		t.Fatalf("Inconsistent p50: expected: %v, actual: %v", time.Millisecond, p)
+	}
+	if p := h1.Percentile(99); p != time.Second {
+		t.Fatalf("Inconsistent p99: expected: %v, actual: %v", time.Second, p)
+	}
+}
+
+func TestCollector(t *testing.T) {
+	c1 := NewCollector()
+	c2 := NewCollector()
+	resultCh := make(chan *lib.CallResult, 10)
+	for i := 0; i < 9; i++ {
+		resultCh <- &lib.CallResult{ID: int64(i), Code: lib.RET_CODE_SUCCESS, Elapse: 10 * time.Millisecond}
+	}
+	resultCh <- &lib.CallResult{ID: 9, Code: lib.RET_CODE_WARNING_CALL_TIMEOUT, Elapse: 50 * time.Millisecond, ResponseTime: 80 * time.Millisecond}
+	close(resultCh)
+	c1.Consume(resultCh)
+	c2.Add(&lib.CallResult{ID: 10, Code: lib.RET_CODE_FATAL_CALL})
+	// 超时的调用之后才返回的真实响应单独统计
+	c2.Add(&lib.CallResult{ID: 9, Code: lib.RET_CODE_SUCCESS, Elapse: 120 * time.Millisecond, Late: true})
+
+	c1.Merge(c2)
+	summary := c1.Snapshot(2 * time.Second)
+	t.Logf("Summary: %s", summary)
+	if summary.Count != 11 {
+		t.Fatalf("Inconsistent count: expected: %d, actual: %d", 11, summary.Count)
+	}
+	if summary.Codes[lib.RET_CODE_SUCCESS] != 9 || summary.Codes[lib.RET_CODE_FATAL_CALL] != 1 {
+		t.Fatalf("Inconsistent code counts: %v", summary.Codes)
+	}
+	if summary.Service.Max != 50*time.Millisecond || !approx(summary.Service.P50, 10*time.Millisecond) {
+		t.Fatalf("Inconsistent service time: %s", summary.Service)
+	}
+	if summary.Response.Max != 80*time.Millisecond || summary.Response.Min != 80*time.Millisecond {
+		t.Fatalf("Inconsistent response time: %s", summary.Response)
+	}
+	if ratio := summary.Ratio(lib.RET_CODE_WARNING_CALL_TIMEOUT, lib.RET_CODE_FATAL_CALL); math.Abs(ratio-2.0/11) > 1e-9 {
+		t.Fatalf("Inconsistent ratio: expected: %f, actual: %f", 2.0/11, ratio)
+	}
+	late := summary.Late
+	if late.Count != 1 || late.Codes[lib.RET_CODE_SUCCESS] != 1 || !approx(late.Service.Max, 120*time.Millisecond) {
+		t.Fatalf("Inconsistent late summary: %+v", late)
+	}
+	// 吞吐量按运行的时长计算，与结果到达的时刻无关
+	if summary.Throughput != 5.5 || summary.TPS != 4.5 {
+		t.Fatalf("Inconsistent throughput: throughput=%f, tps=%f", summary.Throughput, summary.TPS)
+	}
+	single := NewCollector()
+	single.Add(&lib.CallResult{Code: lib.RET_CODE_SUCCESS, Elapse: time.Millisecond})
+	if summary := single.Snapshot(time.Second); summary.Throughput != 1 || summary.TPS != 1 {
+		t.Fatalf("Inconsistent throughput of a single result: throughput=%f, tps=%f", summary.Throughput, summary.TPS)
+	}
+}
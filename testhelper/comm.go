@@ -2,12 +2,13 @@ package testhelper
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"lpstest/lib"
 	"math/rand"
 	"net"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,17 +19,49 @@ const (
 var operators = []string{"+", "-", "*", "/"}
 
 type TCPComm struct {
-	addr string
+	addr  string
+	codec Codec
 }
 
-// 新建一个 TCP 通信
+// 新建一个 TCP 通信，它同时实现了 lib.ContextCaller
 func NewTCPComm(addr string) lib.Caller {
-	return &TCPComm{addr: addr}
+	return NewTCPCommWithCodec(addr, nil)
+}
+
+// 新建一个使用指定帧编解码器的 TCP 通信，codec 为 nil 时以 DELIM 划分帧
+func NewTCPCommWithCodec(addr string, codec Codec) lib.Caller {
+	return &TCPComm{addr: addr, codec: defaultCodec(codec)}
+}
+
+// 获取实际使用的帧编解码器
+func defaultCodec(codec Codec) Codec {
+	if codec == nil {
+		return NewDelimCodec(DELIM, 0)
+	}
+	return codec
+}
+
+// 上一个请求的 ID
+var lastID int64
+
+// 生成一个请求 ID，它接近当前的纳秒时间戳，并且在进程内严格递增，
+// 因此可以在同一个连接上用来配对请求和响应
+func nextID() int64 {
+	for {
+		last := atomic.LoadInt64(&lastID)
+		id := time.Now().UnixNano()
+		if id <= last {
+			id = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&lastID, last, id) {
+			return id
+		}
+	}
 }
 
 // 构建一个请求
 func (comm *TCPComm) BuildRed() lib.RawReq {
-	id := time.Now().UnixNano()
+	id := nextID()
 	sreq := ServerReq{
 		ID: id,
 		Operands: []int{
@@ -49,15 +82,35 @@ func (comm *TCPComm) BuildRed() lib.RawReq {
 
 // 发起一次通信
 func (comm *TCPComm) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
-	conn, err := net.DialTimeout("tcp", comm.addr, timeoutNS)
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutNS)
+	defer cancel()
+	return comm.CallContext(ctx, req)
+}
+
+// 发起一次通信，上下文被取消时会关闭连接以中止读写
+func (comm *TCPComm) CallContext(ctx context.Context, req []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", comm.addr)
 	if err != nil {
 		return nil, err
 	}
-	_, err = write(conn, req, DELIM)
-	if err != nil {
-		return nil, err
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+	err = writeFrame(conn, comm.codec, req)
+	if err == nil {
+		var resp []byte
+		resp, err = comm.codec.ReadFrame(bufio.NewReader(conn))
+		if err == nil {
+			return resp, nil
+		}
 	}
-	return read(conn, DELIM)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	return nil, err
 }
 
 func (comm *TCPComm) CheckResp(rawReq lib.RawReq, rawResp lib.RawResp) *lib.CallResult {
@@ -82,7 +135,7 @@ func (comm *TCPComm) CheckResp(rawReq lib.RawReq, rawResp lib.RawResp) *lib.Call
 	}
 	if sresp.ID != sreq.ID {
 		commResult.Code = lib.RET_CODE_ERROR_RESPONSE
-		commResult.Msg = fmt.Sprintf("Inconsistent raw id! (%d != %d)\n", rawReq.ID, rawResp.ID)
+		commResult.Msg = fmt.Sprintf("Inconsistent raw id! (%d != %d)\n", sreq.ID, sresp.ID)
 		return &commResult
 	}
 	if sresp.Err != nil {
@@ -104,33 +157,11 @@ func (comm *TCPComm) CheckResp(rawReq lib.RawReq, rawResp lib.RawResp) *lib.Call
 	return &commResult
 }
 
-// read 会从连接中读数据直到遇到参数delim代表的字节。
-func read(conn net.Conn, delim byte) ([]byte, error) {
-	readBytes := make([]byte, 1)
-	var buffer bytes.Buffer
-	for {
-		_, err := conn.Read(readBytes)
-		if err != nil {
-			return nil, err
-		}
-		readByte := readBytes[0]
-		if readByte == delim {
-			break
-		}
-		buffer.WriteByte(readByte)
-	}
-	return buffer.Bytes(), nil
-}
-
-// write 会向连接写数据，并在最后追加参数delim代表的字节。
-func write(conn net.Conn, content []byte, delim byte) (int, error) {
+// 向连接写一帧并立即发送
+func writeFrame(conn net.Conn, codec Codec, payload []byte) error {
 	writer := bufio.NewWriter(conn)
-	n, err := writer.Write(content)
-	if err == nil {
-		writer.WriteByte(delim)
-	}
-	if err == nil {
-		err = writer.Flush()
+	if err := codec.WriteFrame(writer, payload); err != nil {
+		return err
 	}
-	return n, err
+	return writer.Flush()
 }
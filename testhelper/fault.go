@@ -0,0 +1,277 @@
+package testhelper
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 声明代表延迟分布的常量
+const (
+	LATENCY_NONE        uint32 = iota // 无延迟
+	LATENCY_FIXED                     // 固定为 Mean
+	LATENCY_UNIFORM                   // 均匀分布在 Min~Max 之间
+	LATENCY_NORMAL                    // 均值为 Mean、标准差为 StdDev 的正态分布，负值取 0
+	LATENCY_EXPONENTIAL               // 均值为 Mean 的指数分布
+)
+
+// 服务器处理每个请求前的延迟的分布
+type Latency struct {
+	Kind   uint32
+	Mean   time.Duration
+	StdDev time.Duration
+	Min    time.Duration
+	// 延迟的上限，对均匀分布以外的分布为 0 时不设上限
+	Max time.Duration
+}
+
+// 解析延迟分布，格式为：
+// none、10ms（固定）、uniform:5ms-20ms、normal:10ms,2ms、exp:10ms，
+// 后两者可以追加 ",max=100ms" 设置上限
+func ParseLatency(s string) (Latency, error) {
+	var latency Latency
+	s = strings.TrimSpace(s)
+	if s == "" || s == "none" {
+		return latency, nil
+	}
+	kind, args, found := strings.Cut(s, ":")
+	if !found {
+		kind, args = "fixed", s
+	}
+	var fields []string
+	for _, field := range strings.Split(args, ",") {
+		if max, ok := strings.CutPrefix(strings.TrimSpace(field), "max="); ok {
+			d, err := time.ParseDuration(max)
+			if err != nil {
+				return latency, fmt.Errorf("Invalid latency %q! (%s)", s, err)
+			}
+			latency.Max = d
+			continue
+		}
+		fields = append(fields, field)
+	}
+	durations := func(fields ...string) ([]time.Duration, error) {
+		ds := make([]time.Duration, len(fields))
+		for i, field := range fields {
+			d, err := time.ParseDuration(strings.TrimSpace(field))
+			if err != nil {
+				return nil, err
+			}
+			ds[i] = d
+		}
+		return ds, nil
+	}
+	var ds []time.Duration
+	var err error
+	switch {
+	case kind == "fixed" && len(fields) == 1:
+		latency.Kind = LATENCY_FIXED
+		if ds, err = durations(fields...); err == nil {
+			latency.Mean = ds[0]
+		}
+	case kind == "uniform" && len(fields) == 1 && strings.Contains(fields[0], "-"):
+		latency.Kind = LATENCY_UNIFORM
+		min, max, _ := strings.Cut(fields[0], "-")
+		if ds, err = durations(min, max); err == nil {
+			latency.Min, latency.Max = ds[0], ds[1]
+		}
+	case kind == "normal" && len(fields) == 2:
+		latency.Kind = LATENCY_NORMAL
+		if ds, err = durations(fields...); err == nil {
+			latency.Mean, latency.StdDev = ds[0], ds[1]
+		}
+	case kind == "exp" && len(fields) == 1:
+		latency.Kind = LATENCY_EXPONENTIAL
+		if ds, err = durations(fields...); err == nil {
+			latency.Mean = ds[0]
+		}
+	default:
+		return latency, fmt.Errorf("Invalid latency %q! (expected: none, 10ms, uniform:5ms-20ms, normal:10ms,2ms or exp:10ms)", s)
+	}
+	if err != nil {
+		return latency, fmt.Errorf("Invalid latency %q! (%s)", s, err)
+	}
+	return latency, latency.check()
+}
+
+// 检查延迟分布的有效性
+func (l Latency) check() error {
+	if l.Kind > LATENCY_EXPONENTIAL {
+		return fmt.Errorf("Invalid latency! (kind=%d)", l.Kind)
+	}
+	if l.Mean < 0 || l.StdDev < 0 || l.Min < 0 || l.Max < 0 {
+		return fmt.Errorf("Invalid latency! (negative duration: %+v)", l)
+	}
+	if l.Kind == LATENCY_UNIFORM && l.Max < l.Min {
+		return fmt.Errorf("Invalid latency! (max %v < min %v)", l.Max, l.Min)
+	}
+	return nil
+}
+
+// 按分布抽取一个延迟
+func (l Latency) sample(r *rand.Rand) time.Duration {
+	var d time.Duration
+	switch l.Kind {
+	case LATENCY_FIXED:
+		d = l.Mean
+	case LATENCY_UNIFORM:
+		d = l.Min + time.Duration(r.Int63n(int64(l.Max-l.Min)+1))
+	case LATENCY_NORMAL:
+		d = l.Mean + time.Duration(r.NormFloat64()*float64(l.StdDev))
+	case LATENCY_EXPONENTIAL:
+		d = time.Duration(r.ExpFloat64() * float64(l.Mean))
+	}
+	if d < 0 {
+		d = 0
+	}
+	if l.Max > 0 && d > l.Max {
+		d = l.Max
+	}
+	return d
+}
+
+// 服务器的故障注入配置，各比例在 0~1 之间，且总和不能超过 1，
+// 每个请求至多被注入一种故障，延迟则对所有请求生效
+type Faults struct {
+	Latency     Latency // 处理每个请求前的延迟
+	WrongResult float64 // 返回错误的计算结果的比例
+	ServerError float64 // 在 ServerResp.Err 中返回服务器错误的比例
+	DropConn    float64 // 不响应并直接断开连接的比例，连接上其他未完成的请求也会失败
+	// 返回错误的 ID 的比例，TCPPool 按 ID 配对响应，收不到配对的响应，因此会把它报告为超时
+	MismatchID float64
+	Hang       float64 // 不响应且一直挂起，直到连接被关闭的比例
+	// 随机数种子，为 0 时使用当前时间
+	Seed int64
+}
+
+// 检查故障注入配置的有效性
+func (f Faults) check() error {
+	if err := f.Latency.check(); err != nil {
+		return err
+	}
+	rates := []struct {
+		name string
+		rate float64
+	}{
+		{"wrong result", f.WrongResult},
+		{"server error", f.ServerError},
+		{"drop conn", f.DropConn},
+		{"mismatch id", f.MismatchID},
+		{"hang", f.Hang},
+	}
+	var total float64
+	for _, r := range rates {
+		if r.rate < 0 || r.rate > 1 {
+			errMsg := fmt.Sprintf("Invalid faults! (%s=%v)", r.name, r.rate)
+			return errors.New(errMsg)
+		}
+		total += r.rate
+	}
+	if total > 1 {
+		errMsg := fmt.Sprintf("Invalid faults! (total rate %v > 1)", total)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// 注入故障的统计快照
+type FaultStats struct {
+	Requests     int64         // 经过故障注入的请求数
+	Delay        time.Duration // 注入的总延迟
+	WrongResults int64         // 返回错误结果的请求数
+	ServerErrors int64         // 返回服务器错误的请求数
+	Dropped      int64         // 不响应并断开连接的请求数
+	Mismatched   int64         // 返回错误 ID 的请求数
+	Hung         int64         // 挂起的请求数
+}
+
+// 一个请求被注入的故障
+type fault int
+
+const (
+	faultNone fault = iota
+	faultWrongResult
+	faultServerError
+	faultDropConn
+	faultMismatchID
+	faultHang
+)
+
+// 故障注入器，可以被多个连接并发地使用
+type faultInjector struct {
+	faults Faults
+	mutex  sync.Mutex // 保护 rand
+	rand   *rand.Rand
+	// 以下为计数器
+	requests int64
+	delayNS  int64
+	counts   [faultHang + 1]int64
+}
+
+func newFaultInjector(faults Faults) *faultInjector {
+	seed := faults.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &faultInjector{faults: faults, rand: rand.New(rand.NewSource(seed))}
+}
+
+// 为一个请求抽取延迟和故障
+func (inj *faultInjector) draw() (time.Duration, fault) {
+	inj.mutex.Lock()
+	delay := inj.faults.Latency.sample(inj.rand)
+	p := inj.rand.Float64()
+	inj.mutex.Unlock()
+
+	f := faultNone
+	for _, c := range []struct {
+		fault fault
+		rate  float64
+	}{
+		{faultWrongResult, inj.faults.WrongResult},
+		{faultServerError, inj.faults.ServerError},
+		{faultDropConn, inj.faults.DropConn},
+		{faultMismatchID, inj.faults.MismatchID},
+		{faultHang, inj.faults.Hang},
+	} {
+		if p < c.rate {
+			f = c.fault
+			break
+		}
+		p -= c.rate
+	}
+	atomic.AddInt64(&inj.requests, 1)
+	atomic.AddInt64(&inj.delayNS, int64(delay))
+	atomic.AddInt64(&inj.counts[f], 1)
+	return delay, f
+}
+
+// 按故障篡改响应
+func (inj *faultInjector) corrupt(sresp *ServerResp, f fault) {
+	switch f {
+	case faultWrongResult:
+		sresp.Result++
+	case faultServerError:
+		sresp.Err = &ServerError{Msg: "Server: Injected fault"}
+	case faultMismatchID:
+		// 请求 ID 总是正数，取反后不会与同一连接上其他未完成的请求配对
+		sresp.ID = -sresp.ID
+	}
+}
+
+// 获取统计快照
+func (inj *faultInjector) stats() FaultStats {
+	return FaultStats{
+		Requests:     atomic.LoadInt64(&inj.requests),
+		Delay:        time.Duration(atomic.LoadInt64(&inj.delayNS)),
+		WrongResults: atomic.LoadInt64(&inj.counts[faultWrongResult]),
+		ServerErrors: atomic.LoadInt64(&inj.counts[faultServerError]),
+		Dropped:      atomic.LoadInt64(&inj.counts[faultDropConn]),
+		Mismatched:   atomic.LoadInt64(&inj.counts[faultMismatchID]),
+		Hung:         atomic.LoadInt64(&inj.counts[faultHang]),
+	}
+}
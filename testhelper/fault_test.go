@@ -0,0 +1,251 @@
+package testhelper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"lpstest/lib"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseLatency(t *testing.T) {
+	valid := map[string]Latency{
+		"":                         {},
+		"none":                     {},
+		"10ms":                     {Kind: LATENCY_FIXED, Mean: 10 * time.Millisecond},
+		"uniform:5ms-20ms":         {Kind: LATENCY_UNIFORM, Min: 5 * time.Millisecond, Max: 20 * time.Millisecond},
+		"normal:10ms,2ms":          {Kind: LATENCY_NORMAL, Mean: 10 * time.Millisecond, StdDev: 2 * time.Millisecond},
+		"exp:10ms,max=100ms":       {Kind: LATENCY_EXPONENTIAL, Mean: 10 * time.Millisecond, Max: 100 * time.Millisecond},
+		"normal:1s, 100ms, max=2s": {Kind: LATENCY_NORMAL, Mean: time.Second, StdDev: 100 * time.Millisecond, Max: 2 * time.Second},
+	}
+	for s, expected := range valid {
+		latency, err := ParseLatency(s)
+		if err != nil {
+			t.Errorf("Parsing %q failing: %s", s, err)
+			continue
+		}
+		if latency != expected {
+			t.Errorf("Inconsistent latency of %q: expected: %+v, actual: %+v", s, expected, latency)
+		}
+	}
+	for _, s := range []string{"fast", "uniform:20ms-5ms", "normal:10ms", "exp:-1ms", "pareto:1ms", "exp:1ms,max=x"} {
+		if _, err := ParseLatency(s); err == nil {
+			t.Errorf("Invalid latency %q should be rejected!", s)
+		}
+	}
+}
+
+func TestLatencySample(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	cases := []struct {
+		latency  Latency
+		min, max time.Duration
+		mean     time.Duration
+	}{
+		{Latency{Kind: LATENCY_FIXED, Mean: 5 * time.Millisecond}, 5 * time.Millisecond, 5 * time.Millisecond, 5 * time.Millisecond},
+		{Latency{Kind: LATENCY_UNIFORM, Min: 10 * time.Millisecond, Max: 20 * time.Millisecond}, 10 * time.Millisecond, 20 * time.Millisecond, 15 * time.Millisecond},
+		{Latency{Kind: LATENCY_NORMAL, Mean: 10 * time.Millisecond, StdDev: 20 * time.Millisecond, Max: 30 * time.Millisecond}, 0, 30 * time.Millisecond, 0},
+		{Latency{Kind: LATENCY_EXPONENTIAL, Mean: 10 * time.Millisecond}, 0, time.Second, 10 * time.Millisecond},
+	}
+	const n = 20000
+	for _, c := range cases {
+		var total time.Duration
+		for i := 0; i < n; i++ {
+			d := c.latency.sample(r)
+			if d < c.min || d > c.max {
+				t.Fatalf("Latency %v out of range [%v, %v]: %+v", d, c.min, c.max, c.latency)
+			}
+			total += d
+		}
+		mean := total / n
+		if c.mean > 0 && (mean < c.mean*95/100 || mean > c.mean*105/100) {
+			t.Errorf("Inconsistent mean latency: expected: %v, actual: %v (%+v)", c.mean, mean, c.latency)
+		}
+	}
+}
+
+func TestFaultsCheck(t *testing.T) {
+	invalid := []Faults{
+		{WrongResult: -0.1},
+		{Hang: 1.5},
+		{WrongResult: 0.6, ServerError: 0.6},
+		{Latency: Latency{Kind: LATENCY_UNIFORM, Min: time.Second}},
+		{Latency: Latency{Kind: 99}},
+	}
+	server := NewTCPServer()
+	for _, faults := range invalid {
+		if err := server.SetFaults(faults); err == nil {
+			t.Errorf("Invalid faults should be rejected: %+v", faults)
+		}
+	}
+	if err := server.SetFaults(Faults{WrongResult: 0.5, Hang: 0.5}); err != nil {
+		t.Errorf("Valid faults rejected: %s", err)
+	}
+}
+
+func TestServerRespJSON(t *testing.T) {
+	for _, sresp := range []ServerResp{
+		{ID: 1, Formula: "1 + 2 = 3", Result: 3},
+		{ID: 2, Err: errors.New("boom")},
+	} {
+		data, err := json.Marshal(sresp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded ServerResp
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("Unmarshaling %s failing: %s", data, err)
+		}
+		if decoded.ID != sresp.ID || decoded.Result != sresp.Result || (decoded.Err == nil) != (sresp.Err == nil) {
+			t.Errorf("Inconsistent response: expected: %+v, actual: %+v", sresp, decoded)
+		}
+		if sresp.Err != nil && decoded.Err.Error() != sresp.Err.Error() {
+			t.Errorf("Inconsistent error: expected: %s, actual: %s", sresp.Err, decoded.Err)
+		}
+	}
+}
+
+// 对注入了故障的服务器发起一次调用，返回调用错误或检查的结果
+func callWithFaults(t *testing.T, faults Faults, timeout time.Duration) (*lib.CallResult, error) {
+	server := NewTCPServer()
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("TCP Server startup failing: %s", err)
+	}
+	defer server.Close()
+	if err := server.SetFaults(faults); err != nil {
+		t.Fatal(err)
+	}
+	comm := NewTCPComm(server.Addr().String())
+	rawReq := comm.BuildRed()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	resp, err := comm.(lib.ContextCaller).CallContext(ctx, rawReq.Req)
+	if stats := server.FaultStats(); stats.Requests != 1 {
+		t.Errorf("Inconsistent fault stats: %+v", stats)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return comm.CheckResp(rawReq, lib.RawResp{ID: rawReq.ID, Resp: resp}), nil
+}
+
+func TestTCPServerFaults(t *testing.T) {
+	codes := []struct {
+		name   string
+		faults Faults
+		code   lib.RetCode
+	}{
+		{"none", Faults{Latency: Latency{Kind: LATENCY_FIXED, Mean: 10 * time.Millisecond}}, lib.RET_CODE_SUCCESS},
+		{"wrong result", Faults{WrongResult: 1}, lib.RET_CODE_ERROR_RESPONSE},
+		{"server error", Faults{ServerError: 1}, lib.RET_CODE_ERROR_CALEE},
+		{"mismatch id", Faults{MismatchID: 1}, lib.RET_CODE_ERROR_RESPONSE},
+	}
+	for _, c := range codes {
+		result, err := callWithFaults(t, c.faults, time.Second)
+		if err != nil {
+			t.Errorf("Call with %s failing: %s", c.name, err)
+			continue
+		}
+		if result.Code != c.code {
+			t.Errorf("Inconsistent code with %s: expected: %d, actual: %d (%s)", c.name, c.code, result.Code, result.Msg)
+		}
+	}
+
+	errs := []struct {
+		name   string
+		faults Faults
+		err    error
+	}{
+		{"drop conn", Faults{DropConn: 1}, io.EOF},
+		{"hang", Faults{Hang: 1}, context.DeadlineExceeded},
+		{"latency", Faults{Latency: Latency{Kind: LATENCY_FIXED, Mean: time.Second}}, context.DeadlineExceeded},
+	}
+	for _, c := range errs {
+		start := time.Now()
+		_, err := callWithFaults(t, c.faults, 50*time.Millisecond)
+		if !errors.Is(err, c.err) {
+			t.Errorf("Inconsistent error with %s: expected: %v, actual: %v", c.name, c.err, err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("Call with %s took too long: %v", c.name, elapsed)
+		}
+	}
+}
+
+func TestTCPPoolFaults(t *testing.T) {
+	server := NewTCPServer()
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("TCP Server startup failing: %s", err)
+	}
+	defer server.Close()
+	latency := Latency{Kind: LATENCY_FIXED, Mean: 20 * time.Millisecond}
+	if err := server.SetFaults(Faults{Latency: latency, MismatchID: 0.3, Seed: 1}); err != nil {
+		t.Fatal(err)
+	}
+	// 所有请求共用一个连接且 ID 相邻，ID 错误的响应不能被配给其他请求
+	pool, err := NewTCPPool(server.Addr().String(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	base := nextID()
+	var wg sync.WaitGroup
+	var succeeded, timedOut int64
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(id int64) {
+			defer wg.Done()
+			req, _ := json.Marshal(ServerReq{ID: id, Operands: []int{1, 2}, Operator: "+"})
+			rawReq := lib.RawReq{ID: id, Req: req}
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			resp, err := pool.CallContext(ctx, rawReq.Req)
+			if errors.Is(err, context.DeadlineExceeded) {
+				atomic.AddInt64(&timedOut, 1)
+				return
+			}
+			if err != nil {
+				t.Errorf("Call failing: %s", err)
+				return
+			}
+			result := pool.CheckResp(rawReq, lib.RawResp{ID: rawReq.ID, Resp: resp})
+			if result.Code != lib.RET_CODE_SUCCESS {
+				t.Errorf("Inconsistent result: code=%d, msg=%s", result.Code, result.Msg)
+				return
+			}
+			atomic.AddInt64(&succeeded, 1)
+		}(base + int64(i))
+	}
+	wg.Wait()
+	// 连接池收不到配对的响应，ID 错误的请求都会超时
+	stats := server.FaultStats()
+	if stats.Mismatched == 0 || timedOut != stats.Mismatched || succeeded != stats.Requests-stats.Mismatched {
+		t.Fatalf("Inconsistent results: succeeded=%d, timed out=%d, fault stats: %+v", succeeded, timedOut, stats)
+	}
+}
+
+func TestTCPServerFaultRates(t *testing.T) {
+	inj := newFaultInjector(Faults{WrongResult: 0.1, ServerError: 0.2, Hang: 0.3, Seed: 1})
+	const n = 100000
+	for i := 0; i < n; i++ {
+		inj.draw()
+	}
+	stats := inj.stats()
+	t.Logf("Fault stats: %+v", stats)
+	expected := map[string][2]int64{
+		"wrong result": {stats.WrongResults, n / 10},
+		"server error": {stats.ServerErrors, n / 5},
+		"hang":         {stats.Hung, n * 3 / 10},
+		"dropped":      {stats.Dropped, 0},
+	}
+	for name, e := range expected {
+		if e[0] < e[1]*95/100 || e[0] > e[1]*105/100 {
+			t.Errorf("Inconsistent %s count: expected: ~%d, actual: %d", name, e[1], e[0])
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package testhelper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 默认的最大帧长度
+const DEFAULT_MAX_FRAME_SIZE = 1 << 20
+
+// 帧的长度超过了上限
+var ErrFrameTooLarge = errors.New("Frame too large!")
+
+// 帧编解码器，负责在字节流中划分消息的边界
+// 同一个编解码器可以被多个连接并发地使用
+type Codec interface {
+	// 把一帧写入 w，调用方负责 Flush
+	WriteFrame(w *bufio.Writer, payload []byte) error
+	// 从 r 中读取一帧，返回的数据在下一次读取之后仍然有效
+	ReadFrame(r *bufio.Reader) ([]byte, error)
+}
+
+// 根据名称新建编解码器：delim、length 或 varint，maxFrame 为 0 时使用 DEFAULT_MAX_FRAME_SIZE
+func NewCodec(name string, maxFrame int) (Codec, error) {
+	switch name {
+	case "delim", "":
+		return NewDelimCodec(DELIM, maxFrame), nil
+	case "length":
+		return NewLengthCodec(maxFrame), nil
+	case "varint":
+		return NewVarintCodec(maxFrame), nil
+	}
+	return nil, fmt.Errorf("Unknown framing %q! (expected: delim, length or varint)", name)
+}
+
+// 获取实际使用的最大帧长度
+func maxFrameSize(maxFrame int) int {
+	if maxFrame <= 0 {
+		return DEFAULT_MAX_FRAME_SIZE
+	}
+	return maxFrame
+}
+
+// 以分隔符结尾的帧
+type delimCodec struct {
+	delim    byte
+	maxFrame int
+}
+
+// 新建一个以 delim 结尾划分帧的编解码器，帧的内容不能包含 delim
+func NewDelimCodec(delim byte, maxFrame int) Codec {
+	return &delimCodec{delim: delim, maxFrame: maxFrameSize(maxFrame)}
+}
+
+func (c *delimCodec) WriteFrame(w *bufio.Writer, payload []byte) error {
+	if len(payload) > c.maxFrame {
+		return ErrFrameTooLarge
+	}
+	if bytes.IndexByte(payload, c.delim) >= 0 {
+		return fmt.Errorf("Invalid frame! (payload contains delimiter %q)", c.delim)
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
+	return w.WriteByte(c.delim)
+}
+
+func (c *delimCodec) ReadFrame(r *bufio.Reader) ([]byte, error) {
+	var frame []byte
+	for {
+		slice, err := r.ReadSlice(c.delim)
+		if len(frame)+len(slice) > c.maxFrame+1 {
+			return nil, ErrFrameTooLarge
+		}
+		frame = append(frame, slice...)
+		if err == nil {
+			return frame[:len(frame)-1], nil
+		}
+		if err != bufio.ErrBufferFull {
+			if err == io.EOF && len(frame) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+}
+
+// 以 4 字节的长度（大端序）为前缀的帧
+type lengthCodec struct {
+	maxFrame int
+}
+
+// 新建一个以 4 字节长度为前缀划分帧的编解码器
+func NewLengthCodec(maxFrame int) Codec {
+	return &lengthCodec{maxFrame: maxFrameSize(maxFrame)}
+}
+
+func (c *lengthCodec) WriteFrame(w *bufio.Writer, payload []byte) error {
+	if len(payload) > c.maxFrame {
+		return ErrFrameTooLarge
+	}
+	var head [4]byte
+	binary.BigEndian.PutUint32(head[:], uint32(len(payload)))
+	if _, err := w.Write(head[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+func (c *lengthCodec) ReadFrame(r *bufio.Reader) ([]byte, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(head[:])
+	if uint64(size) > uint64(c.maxFrame) {
+		return nil, ErrFrameTooLarge
+	}
+	return readPayload(r, int(size))
+}
+
+// 以 varint 编码的长度为前缀的帧
+type varintCodec struct {
+	maxFrame int
+}
+
+// 新建一个以 varint 编码的长度为前缀划分帧的编解码器
+func NewVarintCodec(maxFrame int) Codec {
+	return &varintCodec{maxFrame: maxFrameSize(maxFrame)}
+}
+
+func (c *varintCodec) WriteFrame(w *bufio.Writer, payload []byte) error {
+	if len(payload) > c.maxFrame {
+		return ErrFrameTooLarge
+	}
+	var head [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(head[:], uint64(len(payload)))
+	if _, err := w.Write(head[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+func (c *varintCodec) ReadFrame(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(c.maxFrame) {
+		return nil, ErrFrameTooLarge
+	}
+	return readPayload(r, int(size))
+}
+
+// 读取 size 个字节的帧内容
+func readPayload(r *bufio.Reader, size int) ([]byte, error) {
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return payload, nil
+}
@@ -0,0 +1,218 @@
+package testhelper
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"lpstest/lib"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试用的最大帧长度
+const testMaxFrame = 64
+
+// 所有的编解码器
+func testCodecs() map[string]Codec {
+	return map[string]Codec{
+		"delim":  NewDelimCodec(DELIM, testMaxFrame),
+		"length": NewLengthCodec(testMaxFrame),
+		"varint": NewVarintCodec(testMaxFrame),
+	}
+}
+
+// 把多帧编码后再逐帧解码
+func roundTrip(codec Codec, frames [][]byte) ([][]byte, error) {
+	var buf bytes.Buffer
+	writer := bufio.NewWriter(&buf)
+	for _, frame := range frames {
+		if err := codec.WriteFrame(writer, frame); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	// 使用最小的缓冲，使帧跨越多次读取
+	reader := bufio.NewReaderSize(&buf, 16)
+	var decoded [][]byte
+	for {
+		frame, err := codec.ReadFrame(reader)
+		if err == io.EOF {
+			return decoded, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, frame)
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	frames := [][]byte{
+		[]byte(`{"ID":1}`),
+		{},
+		[]byte(strings.Repeat("x", testMaxFrame)),
+		[]byte("0123456789abcdefghij"),
+	}
+	for name, codec := range testCodecs() {
+		decoded, err := roundTrip(codec, frames)
+		if err != nil {
+			t.Errorf("Round trip of %s failing: %s", name, err)
+			continue
+		}
+		if len(decoded) != len(frames) {
+			t.Errorf("Inconsistent frame count of %s: expected: %d, actual: %d", name, len(frames), len(decoded))
+			continue
+		}
+		for i := range frames {
+			if !bytes.Equal(decoded[i], frames[i]) {
+				t.Errorf("Inconsistent frame %d of %s: expected: %q, actual: %q", i, name, frames[i], decoded[i])
+			}
+		}
+
+		// 超过最大帧长度的帧既不能写也不能读
+		large := []byte(strings.Repeat("x", testMaxFrame+1))
+		writer := bufio.NewWriter(io.Discard)
+		if err := codec.WriteFrame(writer, large); !errors.Is(err, ErrFrameTooLarge) {
+			t.Errorf("Writing a large frame with %s should fail, but got: %v", name, err)
+		}
+		var buf bytes.Buffer
+		writer = bufio.NewWriter(&buf)
+		NewVarintCodec(0).WriteFrame(writer, large)
+		NewLengthCodec(0).WriteFrame(writer, large)
+		NewDelimCodec(DELIM, 0).WriteFrame(writer, large)
+		writer.Flush()
+		if _, err := codec.ReadFrame(bufio.NewReader(&buf)); !errors.Is(err, ErrFrameTooLarge) {
+			t.Errorf("Reading a large frame with %s should fail, but got: %v", name, err)
+		}
+
+		// 截断的帧
+		var truncated bytes.Buffer
+		writer = bufio.NewWriter(&truncated)
+		codec.WriteFrame(writer, frames[0])
+		writer.Flush()
+		data := truncated.Bytes()[:truncated.Len()-1]
+		if _, err := codec.ReadFrame(bufio.NewReader(bytes.NewReader(data))); err != io.ErrUnexpectedEOF {
+			t.Errorf("Reading a truncated frame with %s should fail with unexpected EOF, but got: %v", name, err)
+		}
+	}
+
+	// 以分隔符划分的帧不能包含分隔符，长度前缀的帧可以
+	payload := []byte("line1\nline2")
+	if _, err := roundTrip(NewDelimCodec(DELIM, 0), [][]byte{payload}); err == nil {
+		t.Error("Payload containing the delimiter should be rejected!")
+	}
+	if decoded, err := roundTrip(NewLengthCodec(0), [][]byte{payload}); err != nil || !bytes.Equal(decoded[0], payload) {
+		t.Errorf("Payload containing newlines should be carried by the length codec: %q, %v", decoded, err)
+	}
+	if _, err := NewCodec("xml", 0); err == nil {
+		t.Error("Unknown framing should be rejected!")
+	}
+}
+
+func TestCodecEndToEnd(t *testing.T) {
+	for _, name := range []string{"delim", "length", "varint"} {
+		codec, err := NewCodec(name, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := NewTCPServerWithCodec(codec)
+		if err := server.Listen("127.0.0.1:0"); err != nil {
+			t.Fatalf("TCP Server startup failing: %s", err)
+		}
+		addr := server.Addr().String()
+		callers := map[string]lib.Caller{
+			"comm": NewTCPCommWithCodec(addr, codec),
+		}
+		pool, err := NewTCPPoolWithCodec(addr, 1, codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		callers["pool"] = pool
+		for kind, caller := range callers {
+			rawReq := caller.BuildRed()
+			resp, err := caller.(lib.ContextCaller).CallContext(context.Background(), rawReq.Req)
+			if err != nil {
+				t.Errorf("Call of %s with %s framing failing: %s", kind, name, err)
+				continue
+			}
+			result := caller.CheckResp(rawReq, lib.RawResp{ID: rawReq.ID, Resp: resp, Elapse: time.Millisecond})
+			if result.Code != lib.RET_CODE_SUCCESS {
+				t.Errorf("Inconsistent result of %s with %s framing: %s", kind, name, result.Msg)
+			}
+		}
+		pool.Close()
+		server.Close()
+	}
+}
+
+func FuzzCodecRoundTrip(f *testing.F) {
+	f.Add([]byte(`{"ID":1,"Operands":[1,2],"Operator":"+"}`), []byte("second"))
+	f.Add([]byte{}, []byte{0x80, 0x80, 0x01})
+	f.Add([]byte("with\nnewline"), []byte(strings.Repeat("y", testMaxFrame)))
+	f.Fuzz(func(t *testing.T, a, b []byte) {
+		for name, codec := range testCodecs() {
+			frames := [][]byte{a, b}
+			decoded, err := roundTrip(codec, frames)
+			if err != nil {
+				// 只有超长或包含分隔符的帧才能被拒绝
+				valid := len(a) <= testMaxFrame && len(b) <= testMaxFrame
+				if name == "delim" {
+					valid = valid && bytes.IndexByte(a, DELIM) < 0 && bytes.IndexByte(b, DELIM) < 0
+				}
+				if valid {
+					t.Fatalf("Round trip of %s failing: %s", name, err)
+				}
+				continue
+			}
+			if len(decoded) != 2 || !bytes.Equal(decoded[0], a) || !bytes.Equal(decoded[1], b) {
+				t.Fatalf("Inconsistent round trip of %s: %q", name, decoded)
+			}
+		}
+	})
+}
+
+func FuzzCodecReadFrame(f *testing.F) {
+	f.Add([]byte("abc\n"))
+	f.Add([]byte{0, 0, 0, 3, 'a', 'b', 'c'})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+	f.Add([]byte{0x7f, 'a'})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for name, codec := range testCodecs() {
+			reader := bufio.NewReaderSize(bytes.NewReader(data), 16)
+			// 任意的输入都不能导致恐慌或返回超长的帧
+			for i := 0; i <= len(data); i++ {
+				frame, err := codec.ReadFrame(reader)
+				if err != nil {
+					break
+				}
+				if len(frame) > testMaxFrame {
+					t.Fatalf("Frame of %s exceeds the limit: %d", name, len(frame))
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkCodecs(b *testing.B) {
+	payload := []byte(`{"ID":1700000000000000000,"Operands":[123,456],"Operator":"+"}`)
+	for name, codec := range testCodecs() {
+		b.Run(name, func(b *testing.B) {
+			var buf bytes.Buffer
+			writer := bufio.NewWriter(&buf)
+			for i := 0; i < b.N; i++ {
+				codec.WriteFrame(writer, payload)
+			}
+			writer.Flush()
+			reader := bufio.NewReader(&buf)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := codec.ReadFrame(reader); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
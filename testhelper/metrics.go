@@ -0,0 +1,153 @@
+package testhelper
+
+import (
+	"encoding/json"
+	"lpstest/stats"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 服务器的统计快照
+type ServerStats struct {
+	Requests  int64            // 已读取的请求数
+	Responses int64            // 已写回的响应数
+	Operators map[string]int64 // 已处理的请求中各运算符的数量，不含无法解析的请求
+	Errors    ServerErrorStats
+	// 排队时间：从读取完请求到开始处理，加上等待写回的时间
+	Queueing stats.Latency
+	// 处理时间：计算响应（含注入的延迟）并写回的时间
+	Handling stats.Latency
+	// 请求在服务器中停留的总时间，即排队时间与处理时间之和，
+	// 调用方测得的服务时间与它的差即为网络等开销
+	Total  stats.Latency
+	Conns  ServerConnStats
+	Faults FaultStats
+}
+
+// 服务器的错误计数
+type ServerErrorStats struct {
+	BadRequests  int64 // 无法解析的请求数
+	ServerErrors int64 // 带有 ServerResp.Err 的响应数，含无法解析的请求和注入的故障
+	ReadErrors   int64 // 读取请求出错的次数，不含对方正常断开
+	WriteErrors  int64 // 写回响应出错的次数
+}
+
+// 服务器的统计数据
+type serverMetrics struct {
+	mutex     sync.Mutex
+	requests  int64
+	responses int64
+	operators map[string]int64
+	errors    ServerErrorStats
+	queueing  *stats.Histogram
+	handling  *stats.Histogram
+	total     *stats.Histogram
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		operators: make(map[string]int64),
+		queueing:  stats.NewHistogram(),
+		handling:  stats.NewHistogram(),
+		total:     stats.NewHistogram(),
+	}
+}
+
+// 记录读取到一个请求
+func (m *serverMetrics) request() {
+	m.mutex.Lock()
+	m.requests++
+	m.mutex.Unlock()
+}
+
+// 记录处理了一个请求，sreq 为 nil 表示请求无法解析
+func (m *serverMetrics) handled(sreq *ServerReq, serverError bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if sreq == nil {
+		m.errors.BadRequests++
+	} else {
+		m.operators[sreq.Operator]++
+	}
+	if serverError {
+		m.errors.ServerErrors++
+	}
+}
+
+// 记录写回了一个响应
+func (m *serverMetrics) response(queueing, total time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.responses++
+	m.queueing.Record(queueing)
+	m.handling.Record(total - queueing)
+	m.total.Record(total)
+}
+
+// 记录一次读取错误
+func (m *serverMetrics) readError() {
+	m.mutex.Lock()
+	m.errors.ReadErrors++
+	m.mutex.Unlock()
+}
+
+// 记录一次写回错误
+func (m *serverMetrics) writeError() {
+	m.mutex.Lock()
+	m.errors.WriteErrors++
+	m.mutex.Unlock()
+}
+
+// 获取统计快照
+func (server *TCPServer) Stats() ServerStats {
+	m := server.metrics
+	m.mutex.Lock()
+	s := ServerStats{
+		Requests:  m.requests,
+		Responses: m.responses,
+		Operators: make(map[string]int64, len(m.operators)),
+		Errors:    m.errors,
+		Queueing:  stats.NewLatency(m.queueing),
+		Handling:  stats.NewLatency(m.handling),
+		Total:     stats.NewLatency(m.total),
+	}
+	for operator, n := range m.operators {
+		s.Operators[operator] = n
+	}
+	m.mutex.Unlock()
+	s.Conns = server.ConnStats()
+	s.Faults = server.FaultStats()
+	return s
+}
+
+// 清零统计数据，不影响连接和故障注入的统计
+func (server *TCPServer) ResetStats() {
+	m := server.metrics
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.requests = 0
+	m.responses = 0
+	m.operators = make(map[string]int64)
+	m.errors = ServerErrorStats{}
+	m.queueing.Reset()
+	m.handling.Reset()
+	m.total.Reset()
+}
+
+// 获取以 JSON 格式输出统计快照的 HTTP 处理器，时间以纳秒为单位
+func (server *TCPServer) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(server.Stats()); err != nil {
+			logger.Errorf("Server: Metrics Encode Error: %s", err)
+		}
+	})
+}
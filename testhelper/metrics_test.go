@@ -0,0 +1,107 @@
+package testhelper
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTCPServerStats(t *testing.T) {
+	latency := 5 * time.Millisecond
+	server := startFaultyServer(t, Faults{Latency: Latency{Kind: LATENCY_FIXED, Mean: latency}})
+	pool, err := NewTCPPool(server.Addr().String(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	const n = 50
+	for i := 0; i < n; i++ {
+		callAndCheck(t, pool)
+	}
+	// 无法解析的请求会得到带有错误的响应
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	writeFrame(conn, rawCodec, []byte("not json"))
+	if _, err := rawCodec.ReadFrame(bufio.NewReader(conn)); err != nil {
+		t.Fatal(err)
+	}
+
+	s := server.Stats()
+	t.Logf("Server stats: %+v", s)
+	if s.Requests != n+1 || s.Responses != n+1 {
+		t.Errorf("Inconsistent request counts: requests=%d, responses=%d", s.Requests, s.Responses)
+	}
+	var operators int64
+	for operator, count := range s.Operators {
+		if operator != "+" && operator != "-" && operator != "*" && operator != "/" {
+			t.Errorf("Unexpected operator %q!", operator)
+		}
+		operators += count
+	}
+	if operators != n {
+		t.Errorf("Inconsistent operator count: expected: %d, actual: %d", n, operators)
+	}
+	if s.Errors != (ServerErrorStats{BadRequests: 1, ServerErrors: 1}) {
+		t.Errorf("Inconsistent error stats: %+v", s.Errors)
+	}
+	// 注入的延迟计入处理时间
+	if s.Handling.Min < latency || s.Total.Mean < s.Handling.Mean || s.Queueing.Max > s.Total.Max {
+		t.Errorf("Inconsistent latencies: queueing: %s, handling: %s, total: %s", s.Queueing, s.Handling, s.Total)
+	}
+	if s.Conns.Accepted != 3 || s.Faults.Requests != n+1 {
+		t.Errorf("Inconsistent connection or fault stats: %+v, %+v", s.Conns, s.Faults)
+	}
+
+	server.ResetStats()
+	s = server.Stats()
+	if s.Requests != 0 || s.Responses != 0 || len(s.Operators) != 0 || s.Total.Max != 0 || s.Conns.Accepted != 3 {
+		t.Errorf("Inconsistent stats after reset: %+v", s)
+	}
+}
+
+func TestTCPServerMetricsHandler(t *testing.T) {
+	server := startFaultyServer(t, Faults{ServerError: 1})
+	pool, err := NewTCPPool(server.Addr().String(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	rawReq := pool.BuildRed()
+	if _, err := pool.Call(rawReq.Req, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := httptest.NewServer(server.MetricsHandler())
+	defer metrics.Close()
+	resp, err := http.Get(metrics.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Inconsistent content type: %s", ct)
+	}
+	var s ServerStats
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		t.Fatalf("Decoding metrics failing: %s", err)
+	}
+	if s.Requests != 1 || s.Responses != 1 || s.Errors.ServerErrors != 1 || s.Faults.ServerErrors != 1 || s.Total.Max <= 0 {
+		t.Errorf("Inconsistent metrics: %+v", s)
+	}
+
+	resp, err = http.Post(metrics.URL, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Inconsistent status of POST: expected: %d, actual: %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
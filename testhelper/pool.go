@@ -0,0 +1,351 @@
+package testhelper
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lpstest/lib"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 连接池的统计快照
+type ConnStats struct {
+	Conns        int           // 当前已建立的连接数
+	Dials        int64         // 建立连接的次数，含重连
+	DialErrors   int64         // 建立连接失败的次数
+	Reconnects   int64         // 连接断开后重新建立连接的次数
+	Broken       int64         // 因读写错误而断开的连接数
+	ConnectNS    time.Duration // 建立连接的总耗时
+	MaxConnectNS time.Duration // 建立连接的最大耗时
+}
+
+// 平均每次建立连接的耗时
+func (s ConnStats) MeanConnectNS() time.Duration {
+	if s.Dials == 0 {
+		return 0
+	}
+	return s.ConnectNS / time.Duration(s.Dials)
+}
+
+// 基于持久连接池的 TCP 通信，它同时实现了 lib.ContextCaller
+// 每个连接上可以同时有多个未完成的请求，响应按 ServerReq.ID 与请求配对；
+// 连接断开时，其上未完成的请求都会失败，下一次请求会重新建立连接
+type TCPPool struct {
+	TCPComm
+	slots []*poolSlot
+	next  uint32 // 轮询选择连接的计数
+	stats connCounters
+}
+
+// 连接池的计数器
+type connCounters struct {
+	dials        int64
+	dialErrors   int64
+	reconnects   int64
+	broken       int64
+	connectNS    int64
+	maxConnectNS int64
+}
+
+// 新建一个持有 size 个持久连接的 TCP 通信，连接在第一次使用时或调用 Connect 时建立
+func NewTCPPool(addr string, size int) (*TCPPool, error) {
+	return NewTCPPoolWithCodec(addr, size, nil)
+}
+
+// 新建一个使用指定帧编解码器的连接池，codec 为 nil 时以 DELIM 划分帧
+func NewTCPPoolWithCodec(addr string, size int, codec Codec) (*TCPPool, error) {
+	if size <= 0 {
+		errMsg := fmt.Sprintf("Invalid pool size! (size=%d)", size)
+		return nil, errors.New(errMsg)
+	}
+	pool := &TCPPool{TCPComm: TCPComm{addr: addr, codec: defaultCodec(codec)}}
+	pool.slots = make([]*poolSlot, size)
+	for i := range pool.slots {
+		pool.slots[i] = &poolSlot{pool: pool}
+	}
+	return pool, nil
+}
+
+// 发起一次通信
+func (pool *TCPPool) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutNS)
+	defer cancel()
+	return pool.CallContext(ctx, req)
+}
+
+// 发起一次通信，上下文被取消时放弃等待响应，但不会关闭连接
+func (pool *TCPPool) CallContext(ctx context.Context, req []byte) ([]byte, error) {
+	var sreq struct{ ID int64 }
+	if err := json.Unmarshal(req, &sreq); err != nil {
+		return nil, fmt.Errorf("Invalid request! (%s)", err)
+	}
+	slot := pool.slots[int(atomic.AddUint32(&pool.next, 1))%len(pool.slots)]
+	start := time.Now()
+	conn, connected, err := slot.get(ctx)
+	if connected {
+		// 建立连接的耗时单独记录，不计入这次请求的服务时间
+		lib.RecordConnect(ctx, time.Since(start))
+	}
+	if err != nil {
+		return nil, err
+	}
+	respCh, err := conn.send(ctx, sreq.ID, req)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case resp := <-respCh:
+		return resp.data, resp.err
+	case <-ctx.Done():
+		conn.forget(sreq.ID)
+		return nil, ctx.Err()
+	}
+}
+
+// 预先建立所有连接，使建立连接的耗时不计入请求的时间
+func (pool *TCPPool) Connect(ctx context.Context) error {
+	for _, slot := range pool.slots {
+		if _, _, err := slot.get(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 关闭所有连接
+func (pool *TCPPool) Close() {
+	for _, slot := range pool.slots {
+		slot.close()
+	}
+}
+
+// 获取连接池的统计快照
+func (pool *TCPPool) ConnStats() ConnStats {
+	var conns int
+	for _, slot := range pool.slots {
+		slot.mutex.Lock()
+		if slot.conn != nil {
+			conns++
+		}
+		slot.mutex.Unlock()
+	}
+	return ConnStats{
+		Conns:        conns,
+		Dials:        atomic.LoadInt64(&pool.stats.dials),
+		DialErrors:   atomic.LoadInt64(&pool.stats.dialErrors),
+		Reconnects:   atomic.LoadInt64(&pool.stats.reconnects),
+		Broken:       atomic.LoadInt64(&pool.stats.broken),
+		ConnectNS:    time.Duration(atomic.LoadInt64(&pool.stats.connectNS)),
+		MaxConnectNS: time.Duration(atomic.LoadInt64(&pool.stats.maxConnectNS)),
+	}
+}
+
+// 记录一次建立连接的耗时
+func (pool *TCPPool) recordConnect(elapsed time.Duration) {
+	atomic.AddInt64(&pool.stats.dials, 1)
+	atomic.AddInt64(&pool.stats.connectNS, int64(elapsed))
+	for {
+		max := atomic.LoadInt64(&pool.stats.maxConnectNS)
+		if int64(elapsed) <= max || atomic.CompareAndSwapInt64(&pool.stats.maxConnectNS, max, int64(elapsed)) {
+			return
+		}
+	}
+}
+
+// 连接池中的一个位置，连接断开后会在这里重新建立
+type poolSlot struct {
+	pool    *TCPPool
+	mutex   sync.Mutex
+	conn    *muxConn      // 当前的连接，尚未建立或已断开时为 nil
+	dialing chan struct{} // 正在建立连接时非空，建立完成（无论成败）时被关闭
+	dialed  bool          // 是否曾经建立过连接
+}
+
+// 获取当前的连接，必要时建立连接，建立连接时不持有锁
+// 同一位置上同时只有一个请求建立连接，其余请求等待它完成；
+// 这次获取建立了连接或等待了连接建立时 connected 为 true
+func (slot *poolSlot) get(ctx context.Context) (conn *muxConn, connected bool, err error) {
+	for {
+		slot.mutex.Lock()
+		if slot.conn != nil {
+			conn = slot.conn
+			slot.mutex.Unlock()
+			return conn, connected, nil
+		}
+		dialing := slot.dialing
+		if dialing == nil {
+			break
+		}
+		slot.mutex.Unlock()
+		connected = true
+		select {
+		case <-dialing:
+		case <-ctx.Done():
+			return nil, connected, ctx.Err()
+		}
+	}
+	dialing := make(chan struct{})
+	slot.dialing = dialing
+	slot.mutex.Unlock()
+
+	var dialer net.Dialer
+	start := time.Now()
+	netConn, err := dialer.DialContext(ctx, "tcp", slot.pool.addr)
+	slot.mutex.Lock()
+	slot.dialing = nil
+	if err == nil {
+		slot.pool.recordConnect(time.Since(start))
+		if slot.dialed {
+			atomic.AddInt64(&slot.pool.stats.reconnects, 1)
+		}
+		slot.dialed = true
+		slot.conn = newMuxConn(netConn, slot)
+		conn = slot.conn
+	}
+	slot.mutex.Unlock()
+	close(dialing)
+	if err != nil {
+		atomic.AddInt64(&slot.pool.stats.dialErrors, 1)
+		return nil, true, err
+	}
+	return conn, true, nil
+}
+
+// 连接断开时把它移出，下一次请求会重新建立连接，它仍是当前的连接时返回 true
+func (slot *poolSlot) remove(conn *muxConn) bool {
+	slot.mutex.Lock()
+	defer slot.mutex.Unlock()
+	if slot.conn != conn {
+		return false
+	}
+	slot.conn = nil
+	return true
+}
+
+// 关闭当前的连接
+func (slot *poolSlot) close() {
+	slot.mutex.Lock()
+	conn := slot.conn
+	slot.conn = nil
+	slot.mutex.Unlock()
+	if conn != nil {
+		conn.fail(errors.New("Connection pool closed!"))
+	}
+}
+
+// 一次请求的响应
+type muxResp struct {
+	data []byte
+	err  error
+}
+
+// 多路复用的连接，由一个读取 goroutine 按 ID 把响应分发给等待的请求
+type muxConn struct {
+	conn      net.Conn
+	slot      *poolSlot
+	codec     Codec
+	writer    *bufio.Writer
+	writeLock sync.Mutex // 保护 writer
+	mutex     sync.Mutex // 保护以下字段
+	pending   map[int64]chan muxResp
+	err       error // 连接断开的原因，断开后非 nil
+}
+
+func newMuxConn(conn net.Conn, slot *poolSlot) *muxConn {
+	c := &muxConn{
+		conn:    conn,
+		slot:    slot,
+		codec:   slot.pool.codec,
+		writer:  bufio.NewWriter(conn),
+		pending: make(map[int64]chan muxResp),
+	}
+	go c.readLoop()
+	return c
+}
+
+// 登记并发送一个请求，返回用于等待响应的通道
+func (c *muxConn) send(ctx context.Context, id int64, req []byte) (<-chan muxResp, error) {
+	respCh := make(chan muxResp, 1)
+	c.mutex.Lock()
+	if c.err != nil {
+		c.mutex.Unlock()
+		return nil, c.err
+	}
+	if _, ok := c.pending[id]; ok {
+		c.mutex.Unlock()
+		return nil, fmt.Errorf("Duplicate request ID %d!", id)
+	}
+	c.pending[id] = respCh
+	c.mutex.Unlock()
+
+	c.writeLock.Lock()
+	deadline, _ := ctx.Deadline()
+	c.conn.SetWriteDeadline(deadline)
+	err := c.codec.WriteFrame(c.writer, req)
+	if err == nil {
+		err = c.writer.Flush()
+	}
+	c.writeLock.Unlock()
+	if err != nil {
+		c.fail(err)
+		return nil, err
+	}
+	return respCh, nil
+}
+
+// 放弃等待一个请求的响应，之后到达的响应会被丢弃
+func (c *muxConn) forget(id int64) {
+	c.mutex.Lock()
+	delete(c.pending, id)
+	c.mutex.Unlock()
+}
+
+// 持续读取响应并分发，直到连接断开
+func (c *muxConn) readLoop() {
+	reader := bufio.NewReader(c.conn)
+	for {
+		data, err := c.codec.ReadFrame(reader)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		// 只解析 ID 用于配对，完整的检查由 CheckResp 完成
+		var head struct{ ID int64 }
+		if err := json.Unmarshal(data, &head); err != nil {
+			c.fail(fmt.Errorf("Invalid response! (%s)", err))
+			return
+		}
+		c.mutex.Lock()
+		respCh, ok := c.pending[head.ID]
+		delete(c.pending, head.ID)
+		c.mutex.Unlock()
+		if ok {
+			respCh <- muxResp{data: data}
+		}
+	}
+}
+
+// 断开连接，其上所有未完成的请求都会以 err 失败
+func (c *muxConn) fail(err error) {
+	c.mutex.Lock()
+	if c.err != nil {
+		c.mutex.Unlock()
+		return
+	}
+	c.err = err
+	pending := c.pending
+	c.pending = nil
+	c.mutex.Unlock()
+	if c.slot.remove(c) {
+		atomic.AddInt64(&c.slot.pool.stats.broken, 1)
+	}
+	c.conn.Close()
+	for _, respCh := range pending {
+		respCh <- muxResp{err: err}
+	}
+}
@@ -0,0 +1,247 @@
+package testhelper
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"lpstest/lib"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 启动一个测试用的服务器，返回其地址
+func startServer(t *testing.T) string {
+	server := NewTCPServer()
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("TCP Server startup failing: %s", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server.Addr().String()
+}
+
+// 发起一次调用并检查响应
+func callAndCheck(t *testing.T, pool *TCPPool) {
+	rawReq := pool.BuildRed()
+	resp, err := pool.Call(rawReq.Req, time.Second)
+	if err != nil {
+		t.Errorf("Call failing: %s", err)
+		return
+	}
+	result := pool.CheckResp(rawReq, lib.RawResp{ID: rawReq.ID, Resp: resp})
+	if result.Code != lib.RET_CODE_SUCCESS {
+		t.Errorf("Inconsistent result: code=%d, msg=%s", result.Code, result.Msg)
+	}
+}
+
+func TestTCPPool(t *testing.T) {
+	addr := startServer(t)
+	pool, err := NewTCPPool(addr, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if err := pool.Connect(context.Background()); err != nil {
+		t.Fatalf("Connecting failing: %s", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				callAndCheck(t, pool)
+			}
+		}()
+	}
+	wg.Wait()
+	stats := pool.ConnStats()
+	t.Logf("Connection stats: %+v", stats)
+	// 所有请求都复用预先建立的两个连接
+	if stats.Conns != 2 || stats.Dials != 2 || stats.Reconnects != 0 || stats.Broken != 0 {
+		t.Errorf("Inconsistent connection stats: %+v", stats)
+	}
+	if stats.ConnectNS <= 0 || stats.MaxConnectNS > stats.ConnectNS {
+		t.Errorf("Inconsistent connect time: %+v", stats)
+	}
+	if _, err := NewTCPPool(addr, 0); err == nil {
+		t.Error("Invalid pool size should be rejected!")
+	}
+}
+
+func TestTCPPoolConnectTime(t *testing.T) {
+	addr := startServer(t)
+	pool, err := NewTCPPool(addr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	// 同时发起的请求只建立一次连接，建立连接的耗时记录在触发或等待建立连接的请求上
+	var wg sync.WaitGroup
+	var connectedLock sync.Mutex
+	var connected int
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, connectNS := lib.WithConnectRecorder(context.Background())
+			rawReq := pool.BuildRed()
+			if _, err := pool.CallContext(ctx, rawReq.Req); err != nil {
+				t.Errorf("Call failing: %s", err)
+			}
+			if connectNS() > 0 {
+				connectedLock.Lock()
+				connected++
+				connectedLock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if stats := pool.ConnStats(); stats.Dials != 1 {
+		t.Fatalf("Inconsistent dial count: expected: 1, actual: %d", stats.Dials)
+	}
+	if connected == 0 {
+		t.Fatal("Missing connect time of the request that dialed!")
+	}
+	// 复用连接的请求不记录建立连接的耗时
+	ctx, connectNS := lib.WithConnectRecorder(context.Background())
+	if _, err := pool.CallContext(ctx, pool.BuildRed().Req); err != nil {
+		t.Fatalf("Call failing: %s", err)
+	}
+	if connectNS() != 0 {
+		t.Fatalf("Inconsistent connect time of a reused connection: %v", connectNS())
+	}
+}
+
+// 启动一个按给定方式处理每个连接的服务器，返回其地址
+func startRawServer(t *testing.T, handle func(conn net.Conn, reader *bufio.Reader)) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn, bufio.NewReader(conn))
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// 测试用的服务器使用的帧编解码器
+var rawCodec = NewDelimCodec(DELIM, 0)
+
+// 用于生成正确响应的服务器，它不注入故障，因此处理请求时不会用到连接
+var answerServer = NewTCPServer()
+
+// 读取一个请求并生成正确的响应
+func readAndAnswer(reader *bufio.Reader) ([]byte, error) {
+	req, err := rawCodec.ReadFrame(reader)
+	if err != nil {
+		return nil, err
+	}
+	resp, _ := answerServer.handle(nil, req)
+	return resp, nil
+}
+
+func TestTCPPoolOutOfOrder(t *testing.T) {
+	// 读取两个请求后按相反的顺序响应
+	addr := startRawServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		first, err := readAndAnswer(reader)
+		if err != nil {
+			return
+		}
+		second, err := readAndAnswer(reader)
+		if err != nil {
+			return
+		}
+		writeFrame(conn, rawCodec, second)
+		writeFrame(conn, rawCodec, first)
+	})
+	pool, err := NewTCPPool(addr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			callAndCheck(t, pool)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestTCPPoolReconnect(t *testing.T) {
+	// 每个连接只响应一个请求，然后断开
+	addr := startRawServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		if resp, err := readAndAnswer(reader); err == nil {
+			writeFrame(conn, rawCodec, resp)
+		}
+	})
+	pool, err := NewTCPPool(addr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	for i := 0; i < 3; i++ {
+		callAndCheck(t, pool)
+		// 等待读取 goroutine 发现连接已断开
+		deadline := time.Now().Add(time.Second)
+		for pool.ConnStats().Broken < int64(i+1) && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	stats := pool.ConnStats()
+	t.Logf("Connection stats: %+v", stats)
+	if stats.Dials != 3 || stats.Reconnects != 2 || stats.Broken != 3 || stats.Conns != 0 {
+		t.Errorf("Inconsistent connection stats: %+v", stats)
+	}
+
+	// 连接断开时，其上未完成的请求会失败
+	addr = startRawServer(t, func(conn net.Conn, reader *bufio.Reader) {
+		rawCodec.ReadFrame(reader)
+	})
+	pool, err = NewTCPPool(addr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	rawReq := pool.BuildRed()
+	if _, err := pool.Call(rawReq.Req, time.Second); err == nil || err == context.DeadlineExceeded {
+		t.Errorf("Call on a broken connection should fail fast, but got: %v", err)
+	}
+}
+
+func TestNextID(t *testing.T) {
+	seen := make(map[int64]bool)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				var sreq ServerReq
+				json.Unmarshal((&TCPComm{}).BuildRed().Req, &sreq)
+				mutex.Lock()
+				if seen[sreq.ID] {
+					t.Errorf("Duplicate request ID %d!", sreq.ID)
+				}
+				seen[sreq.ID] = true
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
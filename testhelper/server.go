@@ -1,14 +1,20 @@
 package testhelper
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"lpstest/log"
 	"net"
+	"os"
 	"strconv"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 var logger = log.DLogger()
@@ -28,6 +34,45 @@ type ServerResp struct {
 	Err     error
 }
 
+// 服务器返回的错误，它可以被 JSON 编解码，因此能在 ServerResp.Err 中传递
+type ServerError struct {
+	Msg string
+}
+
+func (e *ServerError) Error() string {
+	return e.Msg
+}
+
+// ServerResp 的 JSON 形式
+type serverRespJSON struct {
+	ID      int64
+	Formula string
+	Result  int
+	Err     *ServerError
+}
+
+// 把 Err 编码为 ServerError
+func (sresp ServerResp) MarshalJSON() ([]byte, error) {
+	j := serverRespJSON{ID: sresp.ID, Formula: sresp.Formula, Result: sresp.Result}
+	if sresp.Err != nil {
+		j.Err = &ServerError{Msg: sresp.Err.Error()}
+	}
+	return json.Marshal(j)
+}
+
+// 把 Err 解码为 *ServerError，没有错误时 Err 为 nil
+func (sresp *ServerResp) UnmarshalJSON(data []byte) error {
+	var j serverRespJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*sresp = ServerResp{ID: j.ID, Formula: j.Formula, Result: j.Result}
+	if j.Err != nil {
+		sresp.Err = j.Err
+	}
+	return nil
+}
+
 func op(operands []int, operator string) int {
 	var result int
 	switch {
@@ -89,47 +134,169 @@ func genFormula(operands []int, operator string, result int, equal bool) string
 	return buff.String()
 }
 
-// 会把参数 sresp 代表的请求转换为数据并发送连接。
-func reqHandler(conn net.Conn) {
-	var errMsg string
-	var sresp ServerResp
-	req, err := read(conn, DELIM)
-	if err != nil {
-		errMsg = fmt.Sprintf("Server: Req Read Error: %s", err)
-	} else {
-		var sreq ServerReq
-		err := json.Unmarshal(req, &sreq)
+// 服务器上的一个连接
+type serverConn struct {
+	net.Conn
+	closed    chan struct{} // 连接关闭时关闭，用于释放被延迟或挂起的请求
+	closeOnce sync.Once
+}
+
+// 关闭连接，可以被多次调用
+func (c *serverConn) close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.Conn.Close()
+	})
+}
+
+// 处理一个连接上的所有请求，直到连接被关闭或服务器关闭
+// 请求会被并发地处理，响应按处理完成的顺序写回，客户端需按 ID 配对
+func (server *TCPServer) connHandler(conn *serverConn) {
+	defer server.untrack(conn)
+	defer conn.close()
+	reader := bufio.NewReader(conn)
+	var writeLock sync.Mutex
+	var handling sync.WaitGroup
+	defer handling.Wait()
+	for {
+		req, err := server.codec.ReadFrame(reader)
 		if err != nil {
-			errMsg = fmt.Sprintf("Server: Req Unmarshal Error: %s", err)
-		} else {
-			sresp.ID = sreq.ID
-			sresp.Result = op(sreq.Operands, sreq.Operator)
-			sresp.Formula = genFormula(sreq.Operands, sreq.Operator, sresp.Result, true)
+			if server.isShuttingDown() && errors.Is(err, os.ErrDeadlineExceeded) {
+				// 服务器正在关闭，不再读取新的请求，但已读取的请求会被处理完
+				return
+			}
+			// 注入的故障会主动关闭连接
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				logger.Errorf("Server: Req Read Error: %s", err)
+				server.metrics.readError()
+			}
+			// 对方已断开，不必再处理未完成的请求
+			conn.close()
+			return
 		}
+		received := time.Now()
+		server.metrics.request()
+		handling.Add(1)
+		go func() {
+			defer handling.Done()
+			start := time.Now()
+			bytes, ok := server.handle(conn, req)
+			if !ok {
+				return
+			}
+			ready := time.Now()
+			writeLock.Lock()
+			locked := time.Now()
+			err := writeFrame(conn, server.codec, bytes)
+			writeLock.Unlock()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					logger.Errorf("Server: Resp Write error: %s", err)
+				}
+				server.metrics.writeError()
+				return
+			}
+			// 等待处理和等待写回的时间都计入排队时间
+			server.metrics.response(start.Sub(received)+locked.Sub(ready), time.Since(received))
+		}()
 	}
-	if errMsg != "" {
-		sresp.Err = errors.New(errMsg)
+}
+
+// 处理一个请求并注入故障，不应响应时返回 false
+func (server *TCPServer) handle(conn *serverConn, req []byte) ([]byte, bool) {
+	f := faultNone
+	inj := server.faults.Load()
+	if inj != nil {
+		var delay time.Duration
+		delay, f = inj.draw()
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-conn.closed:
+				return nil, false
+			}
+		}
+		switch f {
+		case faultHang:
+			<-conn.closed
+			return nil, false
+		case faultDropConn:
+			conn.close()
+			return nil, false
+		}
 	}
-	bytes, err := json.Marshal(sresp)
+	sresp, sreq := handleReq(req)
+	if inj != nil {
+		inj.corrupt(&sresp, f)
+	}
+	server.metrics.handled(sreq, sresp.Err != nil)
+	return marshalResp(sresp), true
+}
+
+// 计算参数 req 代表的请求的响应，请求无法解析时返回的请求为 nil
+func handleReq(req []byte) (ServerResp, *ServerReq) {
+	var sresp ServerResp
+	var sreq ServerReq
+	err := json.Unmarshal(req, &sreq)
 	if err != nil {
-		logger.Errorf("Server: Resp Marshal Error: %s", err)
+		sresp.Err = &ServerError{Msg: fmt.Sprintf("Server: Req Unmarshal Error: %s", err)}
+		return sresp, nil
 	}
-	logger.Infof("Server: Resp Marshal info: %s", sresp)
-	_, err = write(conn, bytes, DELIM)
+	sresp.ID = sreq.ID
+	sresp.Result = op(sreq.Operands, sreq.Operator)
+	sresp.Formula = genFormula(sreq.Operands, sreq.Operator, sresp.Result, true)
+	return sresp, &sreq
+}
+
+// 把响应编码为响应数据
+func marshalResp(sresp ServerResp) []byte {
+	bytes, err := json.Marshal(sresp)
 	if err != nil {
-		logger.Errorf("Server: Resp Write error: %s", err)
+		logger.Errorf("Server: Resp Marshal Error: %s", err)
 	}
+	logger.Infof("Server: Resp Marshal info: %s", bytes)
+	return bytes
+}
+
+// 服务器的连接统计快照
+type ServerConnStats struct {
+	Accepted    int64 // 已接受的连接数
+	Active      int64 // 尚未处理完毕的连接数
+	Completed   int64 // 已处理完毕并关闭的连接数
+	ForceClosed int64 // 关闭服务器时被强制关闭的连接数
 }
 
 // 表示基于 TCP 协议的服务器
 type TCPServer struct {
 	listenner net.Listener
 	active    uint32 // 0-未激活；1-已激活
+	codec     Codec
+	faults    atomic.Pointer[faultInjector] // 为 nil 时不注入故障
+	mutex     sync.Mutex                    // 保护以下两个字段
+	conns     map[*serverConn]struct{}      // 尚未处理完毕的连接
+	closing   bool                          // 是否正在关闭，关闭后不再接受连接
+	running   sync.WaitGroup                // 接受连接的 goroutine 和所有连接的处理
+	metrics   *serverMetrics
+	// 以下为计数器
+	accepted    int64
+	completed   int64
+	forceClosed int64
 }
 
 // 新建一个基于 TCP 协议的服务器
 func NewTCPServer() *TCPServer {
-	return &TCPServer{}
+	return NewTCPServerWithCodec(nil)
+}
+
+// 新建一个使用指定帧编解码器的服务器，codec 为 nil 时以 DELIM 划分帧
+func NewTCPServerWithCodec(codec Codec) *TCPServer {
+	return &TCPServer{
+		codec:   defaultCodec(codec),
+		conns:   make(map[*serverConn]struct{}),
+		metrics: newServerMetrics(),
+	}
 }
 
 func (server *TCPServer) init(addr string) error {
@@ -142,38 +309,182 @@ func (server *TCPServer) init(addr string) error {
 		return err
 	}
 	server.listenner = ln
+	server.mutex.Lock()
+	server.closing = false
+	server.mutex.Unlock()
 	return nil
 }
 
+// 接受连接出错后重试的最短和最长等待时间
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
 func (server *TCPServer) Listen(addr string) error {
 	err := server.init(addr)
 	if err != nil {
 		return err
 	}
+	ln := server.listenner
+	server.running.Add(1)
 	go func() {
+		defer server.running.Done()
+		var delay time.Duration
 		for {
-			if atomic.LoadUint32(&server.active) != 1 {
-				break
-			}
-			conn, err := server.listenner.Accept()
+			conn, err := ln.Accept()
 			if err != nil {
-				if atomic.LoadUint32(&server.active) == 1 {
-					logger.Errorf("Server: Request Acception Error: %s\n", err)
-				} else {
+				if atomic.LoadUint32(&server.active) != 1 || errors.Is(err, net.ErrClosed) {
 					logger.Warnf("Server: Broken acception because of closed network connection.")
+					return
+				}
+				// 例如文件描述符耗尽，等待一段时间后重试，避免空转
+				if delay == 0 {
+					delay = minAcceptDelay
+				} else if delay *= 2; delay > maxAcceptDelay {
+					delay = maxAcceptDelay
 				}
+				logger.Errorf("Server: Request Acception Error: %s (retrying in %v)\n", err, delay)
+				time.Sleep(delay)
 				continue
 			}
-			go reqHandler(conn)
+			delay = 0
+			if sc := server.track(conn); sc != nil {
+				go server.connHandler(sc)
+			}
 		}
 	}()
 	return nil
 }
 
-func (server *TCPServer) Close() bool {
+// 登记一个新接受的连接，服务器正在关闭时关闭它并返回 nil
+func (server *TCPServer) track(conn net.Conn) *serverConn {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.closing {
+		conn.Close()
+		return nil
+	}
+	sc := &serverConn{Conn: conn, closed: make(chan struct{})}
+	server.conns[sc] = struct{}{}
+	server.running.Add(1)
+	atomic.AddInt64(&server.accepted, 1)
+	return sc
+}
+
+// 注销一个处理完毕的连接
+func (server *TCPServer) untrack(conn *serverConn) {
+	server.mutex.Lock()
+	delete(server.conns, conn)
+	server.mutex.Unlock()
+	atomic.AddInt64(&server.completed, 1)
+	server.running.Done()
+}
+
+// 是否正在关闭
+func (server *TCPServer) isShuttingDown() bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.closing
+}
+
+// 设置故障注入，之后到达的请求会按新的配置处理，统计也会重新开始
+func (server *TCPServer) SetFaults(faults Faults) error {
+	if err := faults.check(); err != nil {
+		return err
+	}
+	server.faults.Store(newFaultInjector(faults))
+	return nil
+}
+
+// 关闭故障注入
+func (server *TCPServer) ClearFaults() {
+	server.faults.Store(nil)
+}
+
+// 获取注入故障的统计快照
+func (server *TCPServer) FaultStats() FaultStats {
+	inj := server.faults.Load()
+	if inj == nil {
+		return FaultStats{}
+	}
+	return inj.stats()
+}
+
+// 获取监听的地址，尚未监听时返回 nil
+func (server *TCPServer) Addr() net.Addr {
+	if atomic.LoadUint32(&server.active) != 1 {
+		return nil
+	}
+	return server.listenner.Addr()
+}
+
+// 获取连接统计快照
+func (server *TCPServer) ConnStats() ServerConnStats {
+	accepted := atomic.LoadInt64(&server.accepted)
+	completed := atomic.LoadInt64(&server.completed)
+	return ServerConnStats{
+		Accepted:    accepted,
+		Active:      accepted - completed,
+		Completed:   completed,
+		ForceClosed: atomic.LoadInt64(&server.forceClosed),
+	}
+}
+
+// 停止监听，并不再接受新的连接，已关闭时返回 false
+func (server *TCPServer) stopListening() bool {
+	server.mutex.Lock()
+	server.closing = true
+	server.mutex.Unlock()
 	if !atomic.CompareAndSwapUint32(&server.active, 1, 0) {
 		return false
 	}
 	server.listenner.Close()
 	return true
 }
+
+// 强制关闭所有尚未处理完毕的连接
+func (server *TCPServer) closeConns() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for conn := range server.conns {
+		atomic.AddInt64(&server.forceClosed, 1)
+		conn.close()
+		// 只计数一次
+		delete(server.conns, conn)
+	}
+}
+
+// 优雅地关闭服务器：停止接受连接，不再读取新的请求，并等待已读取的请求处理完毕；
+// ctx 结束时强制关闭剩余的连接，等待它们的处理退出后返回 ctx 的错误
+func (server *TCPServer) Shutdown(ctx context.Context) error {
+	server.stopListening()
+	// 使阻塞在读取上的连接立即返回
+	server.mutex.Lock()
+	for conn := range server.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	server.mutex.Unlock()
+	done := make(chan struct{})
+	go func() {
+		server.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		server.closeConns()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// 立即关闭服务器：停止监听并强制关闭所有连接，但不等待处理退出，已关闭时返回 false
+func (server *TCPServer) Close() bool {
+	if !server.stopListening() {
+		return false
+	}
+	server.closeConns()
+	return true
+}
@@ -0,0 +1,157 @@
+package testhelper
+
+import (
+	"context"
+	"lpstest/lib"
+	"net"
+	"testing"
+	"time"
+)
+
+// 等待条件成立，超时后使测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout waiting for %s!", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// 启动一个注入了故障的服务器
+func startFaultyServer(t *testing.T, faults Faults) *TCPServer {
+	server := NewTCPServer()
+	if err := server.SetFaults(faults); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("TCP Server startup failing: %s", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func TestTCPServerShutdown(t *testing.T) {
+	// 已读取的请求在关闭时会被处理完
+	server := startFaultyServer(t, Faults{Latency: Latency{Kind: LATENCY_FIXED, Mean: 100 * time.Millisecond}})
+	addr := server.Addr().String()
+	comm := NewTCPComm(addr)
+	rawReq := comm.BuildRed()
+	type callResult struct {
+		resp []byte
+		err  error
+	}
+	resultCh := make(chan callResult, 1)
+	go func() {
+		resp, err := comm.Call(rawReq.Req, 5*time.Second)
+		resultCh <- callResult{resp, err}
+	}()
+	waitFor(t, "the request", func() bool { return server.FaultStats().Requests == 1 })
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failing: %s", err)
+	}
+	result := <-resultCh
+	if result.err != nil {
+		t.Fatalf("In-flight call failing: %s", result.err)
+	}
+	if r := comm.CheckResp(rawReq, lib.RawResp{ID: rawReq.ID, Resp: result.resp}); r.Code != lib.RET_CODE_SUCCESS {
+		t.Errorf("Inconsistent result: %s", r.Msg)
+	}
+	stats := server.ConnStats()
+	if stats != (ServerConnStats{Accepted: 1, Completed: 1}) {
+		t.Errorf("Inconsistent connection stats: %+v", stats)
+	}
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Error("The server should not accept connections after shutdown!")
+	}
+	if server.Close() {
+		t.Error("Closing a shut down server should return false!")
+	}
+}
+
+func TestTCPServerShutdownIdle(t *testing.T) {
+	// 空闲的持久连接不会阻塞关闭
+	server := startFaultyServer(t, Faults{})
+	pool, err := NewTCPPool(server.Addr().String(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if err := pool.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	callAndCheck(t, pool)
+	waitFor(t, "the connections", func() bool { return server.ConnStats().Active == 3 })
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failing: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Shutdown with idle connections took too long: %v", elapsed)
+	}
+	if stats := server.ConnStats(); stats != (ServerConnStats{Accepted: 3, Completed: 3}) {
+		t.Errorf("Inconsistent connection stats: %+v", stats)
+	}
+	// 连接池会发现连接已断开
+	waitFor(t, "the broken connections", func() bool { return pool.ConnStats().Broken == 3 })
+}
+
+func TestTCPServerShutdownForce(t *testing.T) {
+	// 挂起的请求在 ctx 结束时被强制关闭
+	server := startFaultyServer(t, Faults{Hang: 1})
+	comm := NewTCPComm(server.Addr().String())
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := comm.Call(comm.BuildRed().Req, 5*time.Second)
+		errCh <- err
+	}()
+	waitFor(t, "the request", func() bool { return server.FaultStats().Hung == 1 })
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Inconsistent shutdown error: expected: %v, actual: %v", context.DeadlineExceeded, err)
+	}
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Error("The hung call should fail!")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The hung call was not released!")
+	}
+	if stats := server.ConnStats(); stats != (ServerConnStats{Accepted: 1, Completed: 1, ForceClosed: 1}) {
+		t.Errorf("Inconsistent connection stats: %+v", stats)
+	}
+}
+
+func TestTCPServerClose(t *testing.T) {
+	server := startFaultyServer(t, Faults{Hang: 1})
+	comm := NewTCPComm(server.Addr().String())
+	errCh := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := comm.Call(comm.BuildRed().Req, 5*time.Second)
+			errCh <- err
+		}()
+	}
+	waitFor(t, "the requests", func() bool { return server.FaultStats().Hung == 2 })
+	if !server.Close() {
+		t.Fatal("Closing failing!")
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errCh; err == nil {
+			t.Error("The hung call should fail!")
+		}
+	}
+	waitFor(t, "the handlers", func() bool { return server.ConnStats().Active == 0 })
+	if stats := server.ConnStats(); stats != (ServerConnStats{Accepted: 2, Completed: 2, ForceClosed: 2}) {
+		t.Errorf("Inconsistent connection stats: %+v", stats)
+	}
+}
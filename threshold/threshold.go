@@ -0,0 +1,356 @@
+package threshold
+
+import (
+	"errors"
+	"fmt"
+	"lpstest/lib"
+	"lpstest/stats"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 阈值的种类
+const (
+	KIND_LATENCY    = iota // 耗时，例如 p95 Elapse < 200ms
+	KIND_ERROR_RATE        // 错误率，例如 error rate of RET_CODE_ERROR_* < 1%
+	KIND_RATE              // 速率，例如 achieved LPS >= 95% of target
+	KIND_COUNT             // 结果数，例如 count >= 1000
+)
+
+// 一条阈值，由表达式解析而来
+type Threshold struct {
+	Expr string // 原始的表达式
+	Kind int    // 阈值的种类
+
+	stat     string        // 耗时的统计量：min、max、mean 或 p<百分位>
+	response bool          // 耗时是否使用响应时间（否则使用服务时间）
+	codes    []lib.RetCode // 计入错误率的结果代码，为空时表示所有未成功的结果
+	op       string        // 比较运算符
+	value    float64       // 阈值，耗时的单位为纳秒，比例在 0~1 之间
+	relative bool          // 速率是否相对于目标载荷量
+}
+
+// 比较运算符
+const opPattern = `(<=|>=|==|<|>)`
+
+var (
+	latencyRegexp   = regexp.MustCompile(`^(min|max|mean|avg|p\d+(?:\.\d+)?)\s+(\w+)\s*` + opPattern + `\s*(\S+)$`)
+	errorRateRegexp = regexp.MustCompile(`^error\s+rate(?:\s+of\s+(.+?))?\s*` + opPattern + `\s*(\d+(?:\.\d+)?)(%?)$`)
+	rateRegexp      = regexp.MustCompile(`^(achieved\s+lps|throughput|tps)\s*` + opPattern + `\s*(\d+(?:\.\d+)?)(%\s*of\s+target)?$`)
+	countRegexp     = regexp.MustCompile(`^count\s*` + opPattern + `\s*(\d+)$`)
+)
+
+// 结果代码的名称
+var codeNames = map[string]lib.RetCode{
+	"RET_CODE_SUCCESS":                lib.RET_CODE_SUCCESS,
+	"RET_CODE_WARNING_CALL_TIMEOUT":   lib.RET_CODE_WARNING_CALL_TIMEOUT,
+	"RET_CODE_WARNING_CALL_ABANDONED": lib.RET_CODE_WARNING_CALL_ABANDONED,
+	"RET_CODE_ERROR_CALL":             lib.RET_CODE_ERROR_CALL,
+	"RET_CODE_ERROR_RESPONSE":         lib.RET_CODE_ERROR_RESPONSE,
+	"RET_CODE_ERROR_CALEE":            lib.RET_CODE_ERROR_CALEE,
+	"RET_CODE_FATAL_CALL":             lib.RET_CODE_FATAL_CALL,
+}
+
+// 解析一条阈值表达式，支持的形式：
+//
+//	<min|max|mean|p50|p95|p99.9...> <Elapse|service|ResponseTime|response> <op> <时长>
+//	error rate [of <结果代码>[,<结果代码>...]] <op> <比例或百分比>
+//	<achieved LPS|throughput|tps> <op> <数值>[% of target]
+//	count <op> <数值>
+//
+// 其中 op 为 <、<=、>、>= 或 ==，结果代码可以是名称（支持 * 通配符，
+// 例如 RET_CODE_ERROR_*）或数值，不指定时表示所有未成功的结果
+func Parse(expr string) (*Threshold, error) {
+	t := &Threshold{Expr: expr}
+	text := strings.ToLower(strings.Join(strings.Fields(expr), " "))
+	if m := latencyRegexp.FindStringSubmatch(text); m != nil {
+		t.Kind = KIND_LATENCY
+		t.stat = m[1]
+		if t.stat == "avg" {
+			t.stat = "mean"
+		}
+		if strings.HasPrefix(t.stat, "p") {
+			p, _ := strconv.ParseFloat(t.stat[1:], 64)
+			if p <= 0 || p > 100 {
+				return nil, fmt.Errorf("Invalid threshold %q! (percentile: %s)", expr, m[1])
+			}
+		}
+		switch m[2] {
+		case "elapse", "service":
+		case "responsetime", "response":
+			t.response = true
+		default:
+			return nil, fmt.Errorf("Invalid threshold %q! (unknown latency: %s)", expr, m[2])
+		}
+		t.op = m[3]
+		d, err := time.ParseDuration(m[4])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid threshold %q! (duration: %s)", expr, m[4])
+		}
+		t.value = float64(d)
+		return t, nil
+	}
+	if m := errorRateRegexp.FindStringSubmatch(text); m != nil {
+		t.Kind = KIND_ERROR_RATE
+		if m[1] != "" {
+			codes, err := parseCodes(m[1])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid threshold %q! (%s)", expr, err)
+			}
+			t.codes = codes
+		}
+		t.op = m[2]
+		t.value, _ = strconv.ParseFloat(m[3], 64)
+		if m[4] == "%" {
+			t.value /= 100
+		}
+		if t.value > 1 {
+			return nil, fmt.Errorf("Invalid threshold %q! (error rate > 100%%)", expr)
+		}
+		return t, nil
+	}
+	if m := rateRegexp.FindStringSubmatch(text); m != nil {
+		t.Kind = KIND_RATE
+		t.stat = strings.Join(strings.Fields(m[1]), " ")
+		t.op = m[2]
+		t.value, _ = strconv.ParseFloat(m[3], 64)
+		if m[4] != "" {
+			t.relative = true
+			t.value /= 100
+		}
+		return t, nil
+	}
+	if m := countRegexp.FindStringSubmatch(text); m != nil {
+		t.Kind = KIND_COUNT
+		t.op = m[1]
+		t.value, _ = strconv.ParseFloat(m[2], 64)
+		return t, nil
+	}
+	return nil, fmt.Errorf("Invalid threshold %q!", expr)
+}
+
+// 解析以逗号分隔的结果代码
+func parseCodes(text string) ([]lib.RetCode, error) {
+	var codes []lib.RetCode
+	for _, name := range strings.Split(text, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if n, err := strconv.Atoi(name); err == nil {
+			codes = append(codes, lib.RetCode(n))
+			continue
+		}
+		matched := false
+		for codeName, code := range codeNames {
+			if name == codeName ||
+				(strings.HasSuffix(name, "*") && strings.HasPrefix(codeName, strings.TrimSuffix(name, "*"))) {
+				codes = append(codes, code)
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("unknown result code: %s", name)
+		}
+	}
+	return codes, nil
+}
+
+// 比较实际值与阈值
+func compare(actual float64, op string, value float64) bool {
+	switch op {
+	case "<":
+		return actual < value
+	case "<=":
+		return actual <= value
+	case ">":
+		return actual > value
+	case ">=":
+		return actual >= value
+	default:
+		return actual == value
+	}
+}
+
+// 评估阈值所需的数据
+type Input struct {
+	Summary   stats.Summary    // 调用结果的统计摘要
+	Service   *stats.Histogram // 服务时间的直方图
+	Response  *stats.Histogram // 响应时间的直方图
+	TargetLPS float64          // 目标载荷量，为 0 时相对于目标的阈值无法通过
+}
+
+// 从收集器生成评估阈值所需的数据，elapsed 为运行的时长，用于计算吞吐量
+func NewInput(c *stats.Collector, elapsed time.Duration, targetLPS float64) Input {
+	service, response := c.Histograms()
+	return Input{
+		Summary:   c.Snapshot(elapsed),
+		Service:   service,
+		Response:  response,
+		TargetLPS: targetLPS,
+	}
+}
+
+// 一条阈值的评估结果
+type Result struct {
+	Expr   string `json:"expr"`          // 阈值表达式
+	Actual string `json:"actual"`        // 实际值
+	Passed bool   `json:"passed"`        // 是否通过
+	Msg    string `json:"msg,omitempty"` // 无法评估时的说明
+}
+
+func (r Result) String() string {
+	verdict := "PASS"
+	if !r.Passed {
+		verdict = "FAIL"
+	}
+	if r.Msg != "" {
+		return fmt.Sprintf("%s %s (%s)", verdict, r.Expr, r.Msg)
+	}
+	return fmt.Sprintf("%s %s (actual: %s)", verdict, r.Expr, r.Actual)
+}
+
+// 评估一条阈值
+func (t *Threshold) Evaluate(in Input) Result {
+	result := Result{Expr: t.Expr}
+	var actual float64
+	switch t.Kind {
+	case KIND_LATENCY:
+		h := in.Service
+		if t.response {
+			h = in.Response
+		}
+		if h == nil || h.Count() == 0 {
+			result.Msg = "no samples"
+			return result
+		}
+		var d time.Duration
+		switch t.stat {
+		case "min":
+			d = h.Min()
+		case "max":
+			d = h.Max()
+		case "mean":
+			d = h.Mean()
+		default:
+			p, _ := strconv.ParseFloat(t.stat[1:], 64)
+			d = h.Percentile(p)
+		}
+		actual = float64(d)
+		result.Actual = d.String()
+	case KIND_ERROR_RATE:
+		if t.codes == nil {
+			actual = 0
+			if in.Summary.Count > 0 {
+				actual = 1 - in.Summary.Ratio(lib.RET_CODE_SUCCESS)
+			}
+		} else {
+			actual = in.Summary.Ratio(t.codes...)
+		}
+		result.Actual = fmt.Sprintf("%.2f%%", actual*100)
+	case KIND_RATE:
+		actual = in.Summary.Throughput
+		if t.stat == "tps" {
+			actual = in.Summary.TPS
+		}
+		result.Actual = fmt.Sprintf("%.2f/s", actual)
+		if t.relative {
+			if in.TargetLPS <= 0 {
+				result.Msg = "no target lps"
+				return result
+			}
+			actual /= in.TargetLPS
+			result.Actual = fmt.Sprintf("%.2f%% of %.2f/s", actual*100, in.TargetLPS)
+		}
+	case KIND_COUNT:
+		actual = float64(in.Summary.Count)
+		result.Actual = strconv.FormatInt(in.Summary.Count, 10)
+	}
+	result.Passed = compare(actual, t.op, t.value)
+	return result
+}
+
+// 一组阈值
+type Set []*Threshold
+
+// 解析一组阈值表达式，返回所有无法解析的表达式的错误
+func ParseSet(exprs ...string) (Set, error) {
+	var set Set
+	var errMsgs []string
+	for _, expr := range exprs {
+		t, err := Parse(expr)
+		if err != nil {
+			errMsgs = append(errMsgs, err.Error())
+			continue
+		}
+		set = append(set, t)
+	}
+	if len(errMsgs) > 0 {
+		return nil, errors.New(strings.Join(errMsgs, " "))
+	}
+	return set, nil
+}
+
+// 解析一组阈值表达式，无法解析时引发恐慌，适合在测试中使用
+func MustParseSet(exprs ...string) Set {
+	set, err := ParseSet(exprs...)
+	if err != nil {
+		panic(err)
+	}
+	return set
+}
+
+// 评估所有阈值
+func (set Set) Evaluate(in Input) Verdict {
+	verdict := Verdict{Passed: true}
+	for _, t := range set {
+		result := t.Evaluate(in)
+		verdict.Results = append(verdict.Results, result)
+		if !result.Passed {
+			verdict.Passed = false
+		}
+	}
+	return verdict
+}
+
+// 对一次运行的裁决
+type Verdict struct {
+	Passed  bool     `json:"passed"`  // 是否通过了所有阈值
+	Results []Result `json:"results"` // 各阈值的评估结果
+}
+
+// 未通过的阈值
+func (v Verdict) Failures() []Result {
+	var failures []Result
+	for _, result := range v.Results {
+		if !result.Passed {
+			failures = append(failures, result)
+		}
+	}
+	return failures
+}
+
+// 未通过时返回描述所有未通过的阈值的错误，否则返回 nil
+func (v Verdict) Err() error {
+	if v.Passed {
+		return nil
+	}
+	var msgs []string
+	for _, result := range v.Failures() {
+		msgs = append(msgs, result.String())
+	}
+	return errors.New("Thresholds not passed: " + strings.Join(msgs, "; "))
+}
+
+func (v Verdict) String() string {
+	var buf strings.Builder
+	for _, result := range v.Results {
+		buf.WriteString(result.String())
+		buf.WriteString("\n")
+	}
+	if v.Passed {
+		buf.WriteString("PASSED\n")
+	} else {
+		buf.WriteString("FAILED\n")
+	}
+	return buf.String()
+}
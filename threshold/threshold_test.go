@@ -0,0 +1,105 @@
+package threshold
+
+import (
+	"lpstest/lib"
+	"lpstest/stats"
+	"testing"
+	"time"
+)
+
+// 生成用于测试的数据：100 个结果，服务时间为 1ms~100ms，其中 2 个响应内容错误、1 个超时
+func newTestInput() Input {
+	c := stats.NewCollector()
+	for i := 1; i <= 100; i++ {
+		result := &lib.CallResult{
+			Code:         lib.RET_CODE_SUCCESS,
+			Elapse:       time.Duration(i) * time.Millisecond,
+			ResponseTime: time.Duration(i+10) * time.Millisecond,
+		}
+		switch i {
+		case 10, 20:
+			result.Code = lib.RET_CODE_ERROR_RESPONSE
+		case 30:
+			result.Code = lib.RET_CODE_WARNING_CALL_TIMEOUT
+		}
+		c.Add(result)
+	}
+	in := NewInput(c, 0, 0)
+	in.Summary.Throughput = 95
+	in.Summary.TPS = 90
+	return in
+}
+
+func TestEvaluate(t *testing.T) {
+	in := newTestInput()
+	in.TargetLPS = 100
+	cases := []struct {
+		expr   string
+		passed bool
+	}{
+		{"p95 Elapse < 200ms", true},
+		{"p95 Elapse < 50ms", false},
+		{"p99 ResponseTime <= 120ms", true},
+		{"max service < 100ms", false},
+		{"mean response > 50ms", true},
+		{"error rate of RET_CODE_ERROR_* < 1%", false},
+		{"error rate of RET_CODE_ERROR_* < 5%", true},
+		{"error rate of RET_CODE_WARNING_CALL_TIMEOUT, 2002 <= 0.03", true},
+		{"error rate < 3%", false},
+		{"achieved LPS >= 95% of target", true},
+		{"achieved LPS >= 96% of target", false},
+		{"tps >= 90", true},
+		{"count == 100", true},
+	}
+	for _, c := range cases {
+		th, err := Parse(c.expr)
+		if err != nil {
+			t.Fatalf("Parsing %q failing: %s", c.expr, err)
+		}
+		result := th.Evaluate(in)
+		t.Log(result)
+		if result.Passed != c.passed {
+			t.Errorf("Inconsistent result of %q: expected: %v, actual: %v (%s)", c.expr, c.passed, result.Passed, result.Actual)
+		}
+	}
+}
+
+func TestVerdict(t *testing.T) {
+	in := newTestInput()
+	set := MustParseSet("p95 Elapse < 200ms", "count >= 100")
+	if verdict := set.Evaluate(in); !verdict.Passed || verdict.Err() != nil {
+		t.Fatalf("The verdict should pass: %s", verdict)
+	}
+	set = MustParseSet("p95 Elapse < 200ms", "achieved LPS >= 95% of target", "error rate < 1%")
+	verdict := set.Evaluate(in)
+	if verdict.Passed || len(verdict.Failures()) != 2 || verdict.Err() == nil {
+		t.Fatalf("The verdict should fail with 2 failures: %s", verdict)
+	}
+	t.Logf("Expected error: %s", verdict.Err())
+
+	// 没有样本时耗时阈值不能通过
+	empty := NewInput(stats.NewCollector(), time.Second, 100)
+	if verdict := MustParseSet("p99 Elapse < 1s").Evaluate(empty); verdict.Passed {
+		t.Fatalf("The verdict without samples should fail: %s", verdict)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	exprs := []string{
+		"p95 Elapse < 2 hours",
+		"p0 Elapse < 1s",
+		"p95 latency < 1s",
+		"error rate of RET_CODE_UNKNOWN < 1%",
+		"error rate < 120%",
+		"achieved LPS >= lots",
+		"anything",
+	}
+	for _, expr := range exprs {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parsing %q should fail!", expr)
+		}
+	}
+	if _, err := ParseSet(exprs...); err == nil {
+		t.Fatal("Parsing an invalid set should fail!")
+	}
+}
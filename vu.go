@@ -0,0 +1,262 @@
+package lpstest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"lpstest/lib"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 闭合模型的载荷发生器的参数
+// 其中 ParamSet 的 LPS、Profile、Arrival 和 MaxInFlight 不起作用，
+// 载荷量由虚拟用户数和思考时间决定
+type VUParamSet struct {
+	ParamSet
+	// 固定的虚拟用户数
+	Users uint32
+	// 用户数曲线，复用载荷曲线，其值表示虚拟用户数，为 nil 时固定为 Users
+	UserProfile lib.LoadProfile
+	// 每个虚拟用户在两次调用之间的思考时间
+	ThinkNS time.Duration
+	// 思考时间的随机波动，实际的思考时间均匀分布在 ThinkNS±ThinkJitterNS 之间
+	ThinkJitterNS time.Duration
+}
+
+// 逐项检查参数，返回所有未通过检查的参数
+func (pset *VUParamSet) Errors() []ParamError {
+	var errs []ParamError
+	for _, e := range pset.ParamSet.Errors() {
+		// 载荷量由虚拟用户数代替
+		if e.Field == "LPS" || e.Field == "Profile" {
+			continue
+		}
+		errs = append(errs, e)
+	}
+	if pset.UserProfile == nil {
+		if pset.Users == 0 || pset.Users > lib.MAX_LPS {
+			errs = append(errs, ParamError{"Users", "Invalid users!"})
+		}
+	} else if max := pset.UserProfile.MaxLPS(); max == 0 || max > lib.MAX_LPS {
+		errs = append(errs, ParamError{"UserProfile", "Invalid user profile!"})
+	}
+	if pset.ThinkNS < 0 || pset.ThinkJitterNS < 0 || pset.ThinkJitterNS > pset.ThinkNS {
+		errs = append(errs, ParamError{"ThinkNS", "Invalid thinkNS!"})
+	}
+	return errs
+}
+
+func (pset *VUParamSet) Check() error {
+	var errMsgs []string
+	for _, e := range pset.Errors() {
+		errMsgs = append(errMsgs, e.Msg)
+	}
+	var buf bytes.Buffer
+	buf.WriteString("Checking the parameters...")
+	if errMsgs != nil {
+		errMsg := strings.Join(errMsgs, " ")
+		buf.WriteString(fmt.Sprintf("Not passed! (%s)", errMsg))
+		logger.Infoln(buf.String())
+		return errors.New(errMsg)
+	}
+	buf.WriteString(fmt.Sprintf("Passed. (timeoutNS=%s, users=%d, thinkNS=%s, durationNS=%s)", pset.TimeoutNS, pset.Users, pset.ThinkNS, pset.DurationNS))
+	logger.Infoln(buf.String())
+	return nil
+}
+
+// 控制循环检查用户数曲线的间隔
+const userCheckIntervalNS = 100 * time.Millisecond
+
+// 闭合模型的载荷发生器，复用开放模型的调用、暂停、排空和中止的实现
+type vuGenerator struct {
+	*myGenerator
+	thinkNS       time.Duration
+	thinkJitterNS time.Duration
+	users         []chan struct{} // 各虚拟用户的停止通道，只在控制循环中使用
+	userNum       uint32          // 当前的虚拟用户数
+	callLock      sync.RWMutex    // 保证停止之后不会再发起新的调用
+}
+
+// 新建一个闭合模型的载荷发生器
+func NewVUGenerator(pset VUParamSet) (lib.VUGenerator, error) {
+	logger.Infoln("New a virtual-user load generator...")
+	if err := pset.Check(); err != nil {
+		return nil, err
+	}
+	profile := pset.UserProfile
+	if profile == nil {
+		var err error
+		profile, err = lib.NewConstantProfile(pset.Users)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ctxCaller, _ := pset.Caller.(lib.ContextCaller)
+	vu := &vuGenerator{
+		myGenerator: &myGenerator{
+			caller:       pset.Caller,
+			ctxCaller:    ctxCaller,
+			timeoutNS:    pset.TimeoutNS,
+			profile:      profile,
+			replanCh:     make(chan struct{}, 1),
+			durationNs:   pset.DurationNS,
+			drainNS:      pset.DrainNS,
+			abortPolicy:  pset.AbortPolicy,
+			status:       lib.STATUS_ORIGINAL,
+			sink:         resultSink(pset.ResultCh, pset.Sink),
+			backpressure: pset.Backpressure,
+			recordLate:   pset.RecordLate,
+		},
+		thinkNS:       pset.ThinkNS,
+		thinkJitterNS: pset.ThinkJitterNS,
+	}
+	logger.Infof("Initializing the load generator...Done. (max users=%d, context caller=%v)",
+		profile.MaxLPS(), ctxCaller != nil)
+	return vu, nil
+}
+
+// 控制循环，按用户数曲线增减虚拟用户，直到停止
+func (vu *vuGenerator) runUsers() {
+	ticker := time.NewTicker(userCheckIntervalNS)
+	defer ticker.Stop()
+	for {
+		vu.adjustUsers(vu.currentProfile().LPS(vu.elapsed()))
+		select {
+		case <-ticker.C:
+		case <-vu.replanCh:
+		case <-vu.ctx.Done():
+			// 等待正在发起调用的虚拟用户，之后它们不会再发起调用
+			vu.callLock.Lock()
+			vu.callLock.Unlock()
+			vu.prepareToStop(context.Cause(vu.ctx))
+			vu.adjustUsers(0)
+			return
+		}
+	}
+}
+
+// 把虚拟用户数调整为 target
+func (vu *vuGenerator) adjustUsers(target uint32) {
+	for uint32(len(vu.users)) < target {
+		stopCh := make(chan struct{})
+		vu.users = append(vu.users, stopCh)
+		go vu.runUser(stopCh)
+	}
+	for uint32(len(vu.users)) > target {
+		last := len(vu.users) - 1
+		close(vu.users[last])
+		vu.users = vu.users[:last]
+	}
+	atomic.StoreUint32(&vu.userNum, uint32(len(vu.users)))
+}
+
+// 一个虚拟用户的循环：构建请求、调用、检查响应，然后思考
+func (vu *vuGenerator) runUser(stopCh <-chan struct{}) {
+	for {
+		// 暂停时等待恢复
+		if resumeCh := vu.pausedCh(); resumeCh != nil {
+			select {
+			case <-resumeCh:
+			case <-stopCh:
+				return
+			case <-vu.ctx.Done():
+				return
+			}
+		}
+		call := vu.beginCall(stopCh)
+		if call == nil {
+			return
+		}
+		vu.syncCall(call)
+		think := vu.thinkTime()
+		if think <= 0 {
+			continue
+		}
+		timer := time.NewTimer(think)
+		select {
+		case <-timer.C:
+		case <-stopCh:
+			timer.Stop()
+			return
+		case <-vu.ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// 在可以发起调用时记录这次调用并返回它，否则返回 nil
+func (vu *vuGenerator) beginCall(stopCh <-chan struct{}) *pendingCall {
+	vu.callLock.RLock()
+	defer vu.callLock.RUnlock()
+	select {
+	case <-stopCh:
+		return nil
+	case <-vu.ctx.Done():
+		return nil
+	default:
+	}
+	// 闭合模型中调用总是在用户就绪时立即发出，不存在协同遗漏
+	return vu.addCall(time.Now())
+}
+
+// 获取一次的思考时间
+func (vu *vuGenerator) thinkTime() time.Duration {
+	if vu.thinkJitterNS <= 0 {
+		return vu.thinkNS
+	}
+	jitter := rand.Int63n(2*int64(vu.thinkJitterNS)+1) - int64(vu.thinkJitterNS)
+	return vu.thinkNS + time.Duration(jitter)
+}
+
+func (vu *vuGenerator) Start() bool {
+	return vu.start(vu.runUsers)
+}
+
+// 闭合模型不使用票池，也没有发送计划，因此统计快照中等待票的次数和时长、
+// 未能发出的载荷数以及落后于时间表的时长总是为 0
+func (vu *vuGenerator) Stats() lib.GenStats {
+	return lib.GenStats{
+		CallCount:   atomic.LoadInt64(&vu.callCount),
+		InFlight:    atomic.LoadInt64(&vu.inFlightNum),
+		Concurrency: vu.Users(),
+		ActiveNS:    vu.active(),
+		Results:     vu.results.snapshot(),
+	}
+}
+
+func (vu *vuGenerator) SetLPS(lps uint32) bool {
+	logger.Warnln("Setting lps is not supported by the virtual-user load generator, set users instead.")
+	return false
+}
+
+// 在运行中调整虚拟用户数，只在已启动或暂停时有效
+func (vu *vuGenerator) SetUsers(users uint32) bool {
+	vu.runLock.Lock()
+	defer vu.runLock.Unlock()
+	if !vu.running() {
+		return false
+	}
+	profile, err := lib.NewConstantProfile(users)
+	if err != nil {
+		logger.Warnf("Setting users failing: %s", err)
+		return false
+	}
+	vu.profileLock.Lock()
+	vu.profile = profile
+	vu.profileLock.Unlock()
+	logger.Infof("Set users to %d.", users)
+
+	// 通知控制循环立即调整虚拟用户数
+	vu.replan()
+	return true
+}
+
+func (vu *vuGenerator) Users() uint32 {
+	return atomic.LoadUint32(&vu.userNum)
+}